@@ -8,16 +8,16 @@ package bot
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"os/exec"
-	"strconv"
 	"sync"
+	"time"
 
 	"github.com/Ar5h71/r4-music-bot/common"
+	"github.com/Ar5h71/r4-music-bot/musicmanager"
 	"github.com/bwmarrin/discordgo"
-	"layeh.com/gopus"
 )
 
 type AudioStreamSession struct {
@@ -25,184 +25,749 @@ type AudioStreamSession struct {
 	mtx  sync.Mutex
 	song *common.Song
 
-	done       chan error
-	stop       chan interface{}
-	voice      *discordgo.VoiceConnection
+	done     chan error
+	source   *mixerSource
+	voice    *discordgo.VoiceConnection
+	settings *AudioSettings
+	volume   *volumeScaler
+	// stream of the song resolved by its source when streaming starts
+	streamInfo *common.StreamInfo
+	// decodes the song to PCM when it can't be passed through. Chosen once
+	// the stream is resolved
+	transcoder Transcoder
+	// equalizer of the guild applied on PCM frames
+	equalizer *equalizer
+	// measures loudness of the song if it isn't cached yet
+	meter *loudnessMeter
+	// integrated loudness of the song in LUFS, from cache or estimated while
+	// it is measured
+	loudness       float64
+	hasLoudness    bool
+	loudnessCached bool
+	// interrupts reading from running ffmpeg process or passthrough stream
+	closeSource func()
+	// opus packets are sent to discord without decoding
+	passthrough bool
+	// passthrough is not used for rest of the song once PCM is needed
+	forcePCM bool
+	// position in the song in 20ms frames of the source audio decoded so far
 	framesSent int
-	paused     bool
-	running    bool
-	err        error
+	// position in the song of the last frame handed over for playback.
+	// Frames buffered while prefetching or paused are not counted
+	framesPlayed int
+	// frames of leading silence skipped, which are not counted as elapsed
+	framesSkipped int
+	// fraction of a source frame carried over when playback speed is not 1
+	frameCarry float64
+	// playback speed of running ffmpeg process
+	speed float64
+	// signals sent once when song is close enough to its end to prefetch or
+	// crossfade into the next song
+	prefetch     chan<- *AudioStreamSession
+	prefetchSent bool
+	nearEnd      chan<- *AudioStreamSession
+	nearEndSent  bool
+	// frames buffered while source is not attached to the mixer
+	prebuffer []bufferedFrame
+	// signalled when the stream is resumed, seeked or stopped
+	resumed  *sync.Cond
+	pausedAt time.Time
+	paused   bool
+	running  bool
+	seeking  bool
+	stopped  bool
+	err      error
+}
+
+// a PCM frame or opus packet buffered while prefetching, with the position in
+// the song after it is played
+type bufferedFrame struct {
+	pcm      []int16
+	opus     []byte
+	position int
 }
 
 var (
-	framerate        = 48000
-	framesize        = 960
-	frameduration    = 20
+	framerate     = 48000
+	framesize     = 960
+	frameduration = 20
+	// encoder bitrate when bitrate of voice channel is not known
 	audioBitRateKbps = 64
 	numChannels      = 2
 	compressionLevel = 10
-	vbr              = "on"
-	application      = "audio"
+	vbr              = true
 	bufferLen        = 100
-	maxBytes         = framesize * (frameduration / 20) * numChannels
+	// max attempts to recover a stream which died before the song ended. The
+	// attempts are reset once the stream plays for a while
+	maxStreamRetries      = 3
+	retryDelay            = time.Second
+	retryBudgetResetAfter = 30 * time.Second
+	// stream ending this close to song duration is treated as end of the song
+	songEndTolerance = 3 * time.Second
+	// source is reopened after a pause longer than this as its connection might
+	// have timed out
+	reopenAfterPause = time.Minute
+	// time before end of a song when next song starts decoding
+	prefetchLeadTime = 10 * time.Second
+	// max frames buffered for a prefetched song
+	prefetchFrames = 50
+	maxBytes       = framesize * (frameduration / 20) * numChannels
 )
 
-func NewAudioStream(song *common.Song, voice *discordgo.VoiceConnection, done chan error) *AudioStreamSession {
-	log.Printf("[%s(%s)]: Creating new stream session for song with url '%s'", song.SongTitle, song.SongId, song.SongUrl)
+func NewAudioStream(song *common.Song, source *mixerSource, voice *discordgo.VoiceConnection, settings *AudioSettings,
+	prefetch, nearEnd chan<- *AudioStreamSession, done chan error) *AudioStreamSession {
+	log.Printf("[%s(%s)]: Creating new stream session for song from '%s'", song.SongTitle, song.SongId, song.Source)
+	loudness, cached := songLoudness(song)
 	audioStream := &AudioStreamSession{
-		song:   song,
-		voice:  voice,
-		done:   done,
-		paused: false,
-		stop:   make(chan interface{}),
+		song:         song,
+		source:       source,
+		voice:        voice,
+		settings:     settings,
+		prefetch:     prefetch,
+		nearEnd:      nearEnd,
+		volume:       newVolumeScaler(float64(settings.getVolume()) / 100),
+		meter:        newLoudnessMeter(),
+		equalizer:    newEqualizer(),
+		streamInfo:   songStream(song),
+		done:         done,
+		paused:       false,
+		framesSent:   durationToFrames(song.StartAt),
+		framesPlayed: durationToFrames(song.StartAt),
+		speed:        1,
 	}
+	audioStream.resumed = sync.NewCond(&audioStream.mtx)
+	audioStream.loudness, audioStream.hasLoudness, audioStream.loudnessCached = loudness, cached, cached
 
 	go audioStream.stream()
 	return audioStream
 }
 
+// convert a duration to number of 20ms frames
+func durationToFrames(duration time.Duration) int {
+	return int(duration / (time.Duration(frameduration) * time.Millisecond))
+}
+
+// convert number of 20ms frames to a duration
+func framesToDuration(frames int) time.Duration {
+	return time.Duration(frames*frameduration) * time.Millisecond
+}
+
 func (audioStream *AudioStreamSession) stream() {
+	audioStream.mtx.Lock()
 	if audioStream.running {
+		audioStream.mtx.Unlock()
 		log.Printf("[%s(%s)]: Stream already running for song", audioStream.song.SongTitle, audioStream.song.SongId)
 		return
 	}
+	audioStream.running = true
+	audioStream.mtx.Unlock()
 
-	args := []string{
-		"-i", audioStream.song.SongUrl,
-		"-reconnect", "1",
-		"-reconnect_at_eof", "1",
-		"-reconnect_streamed", "1",
-		"-reconnect_delay_max", "2",
-		"-vn",
-		"-f", "s16le",
-		"-vbr", vbr,
-		"-compression_level", strconv.Itoa(compressionLevel),
-		"-ar", strconv.Itoa(int(framerate)),
-		"-ac", strconv.Itoa(int(numChannels)),
-		"-frame_duration", strconv.Itoa(int(frameduration)),
-		"-b:a", strconv.Itoa(audioBitRateKbps * 1000),
-		"-application", application,
-		"pipe:1",
-	}
+	logCtx := fmt.Sprintf("[%s(%s)]", audioStream.song.SongTitle, audioStream.song.SongId)
 
-	run := exec.Command("ffmpeg", args...)
+	// let the mixer know that the source has ended
+	defer close(audioStream.source.frames)
 
-	ffmpegOut, err := run.StdoutPipe()
-	if err != nil {
+	// number of recoveries since the stream last made progress
+	retries := 0
+	retryPosition := 0
+	for {
+		err := audioStream.streamFromCurrentFrame(logCtx)
+
+		audioStream.mtx.Lock()
+		seeking := audioStream.seeking
+		stopped := audioStream.stopped
+		position := audioStream.framesSent
+		audioStream.seeking = false
+		audioStream.mtx.Unlock()
+
+		// restart ffmpeg from the new position if stream was seeked
+		if seeking && !stopped {
+			log.Printf("%s Restarting stream at %s", logCtx, framesToDuration(position).String())
+			continue
+		}
+		// restart the stream from last position if it died before the song ended
+		if !stopped && audioStream.endedEarly(err, position) {
+			if position-retryPosition > durationToFrames(retryBudgetResetAfter) {
+				retries = 0
+			}
+			if retries < maxStreamRetries {
+				retries++
+				retryPosition = position
+				log.Printf("%s Stream ended at %s before song ended. Got error: %v. Recovering, attempt %d of %d",
+					logCtx, framesToDuration(position).String(), err, retries, maxStreamRetries)
+				time.Sleep(time.Duration(retries) * retryDelay)
+				// retrying can't help if the song can't be resolved anymore
+				refreshErr := audioStream.refreshStreamUrl(logCtx)
+				if refreshErr == nil {
+					continue
+				}
+				log.Printf("%s Stopping stream recovery as stream url couldn't be resolved. Got error: %s", logCtx, refreshErr.Error())
+				err = fmt.Errorf("%w. Failed to resolve stream: %v", errStreamRecoveryFailed, refreshErr)
+			} else {
+				log.Printf("%s Failed to recover stream after %d attempts", logCtx, retries)
+				err = fmt.Errorf("%w after %d attempts. Last error: %v", errStreamRecoveryFailed, retries, err)
+			}
+		}
+		if stopped {
+			err = nil
+		} else {
+			// song might have ended while it was prefetched
+			audioStream.flushPrebuffer()
+			audioStream.cacheLoudness(logCtx)
+		}
 		audioStream.err = err
-		log.Printf("[%s(%s)]: Failed to create stdout pipe for buffer. Got error: %s", audioStream.song.SongTitle, audioStream.song.SongId, err.Error())
 		audioStream.done <- err
 		return
 	}
-	ffmpegbuf := bufio.NewReaderSize(ffmpegOut, 16348)
+}
 
-	// start the command
-	audioStream.err = run.Start()
+// start transcoder from the current frame position and send PCM to the mixer till
+// the song ends or stream is interrupted by a seek or stop
+func (audioStream *AudioStreamSession) streamFromCurrentFrame(logCtx string) error {
+	err := audioStream.resolveStream(logCtx)
 	if err != nil {
-		log.Printf("[%s(%s)]: Failed to start ffmpeg command. Error: [%s]", audioStream.song.SongTitle, audioStream.song.SongId, err.Error())
-		audioStream.done <- err
-		return
+		return err
+	}
+	audioStream.mtx.Lock()
+	canPassthrough := audioStream.canPassthrough()
+	audioStream.mtx.Unlock()
+	if canPassthrough {
+		err := audioStream.passthroughFromCurrentFrame(logCtx)
+		if !isPassthroughUnsupported(err) {
+			return err
+		}
+		log.Printf("%s Can't pass stream through, falling back to ffmpeg. Reason: %s", logCtx, err.Error())
+		audioStream.mtx.Lock()
+		audioStream.forcePCM = true
+		audioStream.mtx.Unlock()
 	}
-	defer run.Process.Kill()
-	go func() {
-		// kill the process if stop received
-		<-audioStream.stop
-		audioStream.done <- run.Process.Kill()
-	}()
-
-	// channels to send packets to discord
-	sendbuf := make(chan []int16, 2)
-	close := make(chan interface{})
 
-	go func() {
-		SendPCMPacket(fmt.Sprintf("[%s(%s)]", audioStream.song.SongTitle, audioStream.song.SongId), audioStream.voice, sendbuf)
-		close <- true
-	}()
+	audioStream.mtx.Lock()
+	if audioStream.stopped {
+		audioStream.mtx.Unlock()
+		return nil
+	}
+	audioStream.passthrough = false
+	filter, speed := audioStream.settings.filterChain()
+	offset := framesToDuration(audioStream.framesSent)
+	pcm, err := audioStream.transcoder.Open(audioStream.streamInfo.Url, offset, filter)
+	if err == errFiltersNotSupported {
+		log.Printf("%s Transcoder can't apply filters, playing without them", logCtx)
+		filter, speed = "", 1
+		pcm, err = audioStream.transcoder.Open(audioStream.streamInfo.Url, offset, filter)
+	}
+	if err != nil {
+		audioStream.mtx.Unlock()
+		log.Printf("%s: Failed to open transcoder. Error: [%s]", logCtx, err.Error())
+		return err
+	}
+	audioStream.speed = speed
+	audioStream.frameCarry = 0
+	audioStream.closeSource = func() {
+		pcm.Close()
+	}
+	audioStream.mtx.Unlock()
+	defer pcm.Close()
+	pcmbuf := bufio.NewReaderSize(pcm, 16348)
+	// leading silence is only skipped when the song is played from its start
+	silence := newSilenceDetector(offset == 0)
 
-	// start reading data from stdout
+	// start reading PCM from transcoder. ffmpeg blocks on the full pipe while
+	// the stream is paused
 	for {
-		// check if stream is paused
-		if audioStream.paused {
-			continue
+		if audioStream.waitWhilePaused() {
+			return nil
 		}
 		audioBuf := make([]int16, framesize*numChannels)
-		err = binary.Read(ffmpegbuf, binary.LittleEndian, &audioBuf)
+		err = binary.Read(pcmbuf, binary.LittleEndian, &audioBuf)
+		if err != nil && audioStream.interrupted() {
+			return nil
+		}
 		if err == io.EOF || err == io.ErrUnexpectedEOF {
-			audioStream.done <- err
-			audioStream.err = err
-			return
+			// play end of the song held back by silence detection, unless it is
+			// silence lasting till the end
+			trailing := silence.trailingSilence()
+			frames := silence.flush()
+			if frames == nil && trailing > 0 {
+				log.Printf("%s Trimmed %s of trailing silence", logCtx, trailing.String())
+			}
+			audioStream.sendFrames(frames, filter)
+			return err
 		}
 		if err != nil {
-			log.Printf("[%s(%s)]Failed to read audio buffer: error: [%s]", audioStream.song.SongTitle, audioStream.song.SongId, err.Error())
-			audioStream.err = err
-			audioStream.done <- err
-			return
+			log.Printf("%sFailed to read audio buffer: error: [%s]", logCtx, err.Error())
+			return err
 		}
-		// Send received PCM to the sendPCM channel
-		select {
-		case sendbuf <- audioBuf:
+
+		audioStream.mtx.Lock()
+		if audioStream.seeking || audioStream.stopped {
+			audioStream.mtx.Unlock()
+			return nil
+		}
+		audioStream.advanceFrames()
+		audioStream.checkNearEnd()
+		position := audioStream.framesSent
+		audioStream.mtx.Unlock()
+
+		// silence is detected on decoded audio before volume is applied
+		frames := silence.addFrame(audioBuf, position, audioStream.settings.getTrimSilence())
+		if skipped := silence.takeSkipped(); skipped > 0 {
 			audioStream.mtx.Lock()
-			audioStream.framesSent += 2
+			audioStream.framesSkipped += skipped
 			audioStream.mtx.Unlock()
-		case <-close:
-			audioStream.done <- nil
-			return
+		}
+		if !audioStream.sendFrames(frames, filter) {
+			// faded out or removed from mixer
+			return nil
 		}
 
+		audioStream.mtx.Lock()
+		trimmed := audioStream.trailingSilenceEnded(silence.trailingSilence())
+		audioStream.mtx.Unlock()
+		if trimmed {
+			log.Printf("%s Trimming trailing silence at %s", logCtx, framesToDuration(position).String())
+			return nil
+		}
 	}
+}
 
+// apply guild equalizer, volume and loudness normalization on decoded frames
+// and send them to the mixer. Loudness is measured before equalizer. Returns
+// false if the source was removed
+func (audioStream *AudioStreamSession) sendFrames(frames []bufferedFrame, filter string) bool {
+	for _, frame := range frames {
+		if frame.pcm == nil {
+			// silence held without its samples
+			frame.pcm = make([]int16, framesize*numChannels)
+		}
+		gain := audioStream.targetGain(frame.pcm, frame.position, filter)
+		eqGains, _ := audioStream.settings.getEqualizer()
+		audioStream.equalizer.process(frame.pcm, eqGains)
+		audioStream.volume.scale(frame.pcm, gain)
+		if !audioStream.sendFrame(frame.pcm, frame.position) {
+			return false
+		}
+	}
+	return true
+}
+
+// send a frame to the mixer. While the source is not attached to the mixer
+// frames are buffered, after which the stream waits for the source to be
+// attached. Position is the position in the song after the frame is played.
+// Returns false if the source was removed
+func (audioStream *AudioStreamSession) sendFrame(frame []int16, position int) bool {
+	source := audioStream.source
+	select {
+	case <-source.attached:
+	default:
+		if len(audioStream.prebuffer) < prefetchFrames {
+			audioStream.prebuffer = append(audioStream.prebuffer, bufferedFrame{pcm: frame, position: position})
+			return true
+		}
+	}
+	if !audioStream.flushPrebuffer() {
+		return false
+	}
+	return audioStream.sendPCMFrame(frame, position)
+}
+
+// send a PCM frame to the mixer. Returns false if the source was removed
+func (audioStream *AudioStreamSession) sendPCMFrame(frame []int16, position int) bool {
+	select {
+	case audioStream.source.frames <- frame:
+		audioStream.setPlayed(position)
+		return true
+	case <-audioStream.source.removed:
+		return false
+	}
+}
+
+// update position of played frames unless the stream is being seeked, in
+// which case position is already set to the seeked position
+func (audioStream *AudioStreamSession) setPlayed(position int) {
+	audioStream.mtx.Lock()
+	defer audioStream.mtx.Unlock()
+	if !audioStream.seeking {
+		audioStream.framesPlayed = position
+	}
 }
 
-func SendPCMPacket(logCtx string, voice *discordgo.VoiceConnection, buf <-chan []int16) {
+// advance song position by one sent frame scaled by playback speed. Must be
+// called with mutex held
+func (audioStream *AudioStreamSession) advanceFrames() {
+	audioStream.frameCarry += audioStream.speed
+	frames := int(audioStream.frameCarry)
+	audioStream.framesSent += frames
+	audioStream.frameCarry -= float64(frames)
+}
+
+// wait for the source to be attached to the mixer and send frames buffered
+// while prefetching. Returns false if the source was removed
+func (audioStream *AudioStreamSession) flushPrebuffer() bool {
+	source := audioStream.source
+	if len(audioStream.prebuffer) == 0 {
+		return true
+	}
+	select {
+	case <-source.attached:
+	case <-source.removed:
+		return false
+	}
+	for _, buffered := range audioStream.prebuffer {
+		sent := false
+		if buffered.opus != nil {
+			sent = audioStream.sendOpusPacket(buffered.opus, buffered.position)
+		} else {
+			sent = audioStream.sendPCMFrame(buffered.pcm, buffered.position)
+		}
+		if !sent {
+			return false
+		}
+	}
+	audioStream.prebuffer = nil
+	return true
+}
+
+// signal the queue once remaining playback time is within prefetch or
+// crossfade duration. Must be called with mutex held
+func (audioStream *AudioStreamSession) checkNearEnd() {
+	if audioStream.song.SongDuration == 0 {
+		return
+	}
+	remaining := audioStream.song.SongDuration - framesToDuration(audioStream.framesSent)
+	remaining = time.Duration(float64(remaining) / audioStream.speed)
+	crossfade := audioStream.settings.getCrossfade()
+
+	if remaining <= crossfade+prefetchLeadTime {
+		audioStream.sendPrefetch()
+	}
+	if audioStream.nearEnd != nil && !audioStream.nearEndSent && crossfade > 0 && remaining <= crossfade {
+		audioStream.nearEndSent = true
+		select {
+		case audioStream.nearEnd <- audioStream:
+		default:
+		}
+	}
+}
+
+// signal the queue once to start decoding the next song. Must be called with
+// mutex held
+func (audioStream *AudioStreamSession) sendPrefetch() {
+	if audioStream.prefetch == nil || audioStream.prefetchSent {
+		return
+	}
+	audioStream.prefetchSent = true
+	select {
+	case audioStream.prefetch <- audioStream:
+	default:
+	}
+}
+
+// check if silence held back since the last played frame lasts till the
+// reported end of the song, so the song can end without decoding the rest of
+// it. Silence lasting till the end of the stream is dropped when the stream
+// ends. Must be called with mutex held
+func (audioStream *AudioStreamSession) trailingSilenceEnded(silence time.Duration) bool {
+	duration := audioStream.song.SongDuration
+	if silence < trailingSilenceDuration || duration == 0 {
+		return false
+	}
+	remaining := duration - framesToDuration(audioStream.framesPlayed)
+	remaining = time.Duration(float64(remaining) / audioStream.speed)
+	return remaining-silence <= songEndTolerance
+}
+
+// gain for a frame from guild volume and loudness normalization. Frames are
+// measured till loudness of the song is known
+func (audioStream *AudioStreamSession) targetGain(frame []int16, position int, filter string) float64 {
+	gain := float64(audioStream.settings.getVolume()) / 100
+	normalize, targetLoudness := audioStream.settings.getNormalization()
+	if !normalize {
+		return gain
+	}
+	// filters change loudness, so only unfiltered audio is measured
+	if !audioStream.loudnessCached && filter == "" {
+		// update running estimate once per sub block
+		if audioStream.meter.addFrame(frame, position) {
+			if loudness, ok := audioStream.meter.integrated(); ok {
+				audioStream.loudness, audioStream.hasLoudness = loudness, true
+			}
+		}
+	}
+	if !audioStream.hasLoudness {
+		// nothing measured yet
+		return gain
+	}
+	return gain * normalizationGain(audioStream.loudness, targetLoudness)
+}
+
+// cache loudness of the song once most of it has been measured so repeat
+// plays are normalized from the start
+func (audioStream *AudioStreamSession) cacheLoudness(logCtx string) {
+	if audioStream.loudnessCached {
+		return
+	}
+	measured := framesToDuration(audioStream.meter.measuredFrames())
+	if measured < time.Duration(float64(audioStream.song.SongDuration)*cacheMeasuredFraction) {
+		return
+	}
+	loudness, ok := audioStream.meter.integrated()
+	if !ok {
+		return
+	}
+	log.Printf("%s Measured integrated loudness %.1f LUFS", logCtx, loudness)
+	setCachedLoudness(audioStream.song.SongId, loudness)
+}
+
+// block while the stream is paused. Returns true if the stream was asked to
+// seek or stop
+func (audioStream *AudioStreamSession) waitWhilePaused() bool {
+	audioStream.mtx.Lock()
+	defer audioStream.mtx.Unlock()
+	for audioStream.paused && !audioStream.seeking && !audioStream.stopped {
+		audioStream.resumed.Wait()
+	}
+	return audioStream.seeking || audioStream.stopped
+}
+
+var errStreamRecoveryFailed = errors.New("Failed to recover stream")
+
+// check if the stream ended with an error or before the end of the song
+func (audioStream *AudioStreamSession) endedEarly(err error, position int) bool {
+	if err == nil {
+		return false
+	}
+	if err != io.EOF && err != io.ErrUnexpectedEOF {
+		return true
+	}
+	// end of live streams is not known
+	duration := audioStream.song.SongDuration
+	return duration > 0 && framesToDuration(position) < duration-songEndTolerance
+}
+
+// resolve stream of the song if it isn't resolved yet or its url has
+// expired, e.g. after a long pause
+func (audioStream *AudioStreamSession) resolveStream(logCtx string) error {
+	audioStream.mtx.Lock()
+	stream := audioStream.streamInfo
+	// stream might have been resolved with the song before
+	if stream != nil && audioStream.transcoder == nil {
+		audioStream.transcoder = transcoderFor(stream.Url)
+	}
+	audioStream.mtx.Unlock()
+	if stream != nil && !musicmanager.StreamUrlExpired(stream.Url) {
+		return nil
+	}
+	if stream != nil {
+		log.Printf("%s Stream url has expired, fetching a new one", logCtx)
+	}
+	return audioStream.refreshStreamUrl(logCtx)
+}
+
+// resolve a new stream of the song from its source
+func (audioStream *AudioStreamSession) refreshStreamUrl(logCtx string) error {
+	stream, err := musicmanager.StreamURL(audioStream.song)
+	if err != nil {
+		log.Printf("%s Failed to resolve stream url. Got error: %s", logCtx, err.Error())
+		return err
+	}
+	audioStream.mtx.Lock()
+	audioStream.streamInfo = stream
+	audioStream.transcoder = transcoderFor(stream.Url)
+	audioStream.mtx.Unlock()
+	setSongStream(audioStream.song, stream)
+	return nil
+}
+
+// guards stream of songs. Stream sessions of the same song share the stream,
+// e.g. when the song is restarted
+var songStreamMtx sync.Mutex
+
+// get resolved stream of a song, nil if it isn't resolved yet
+func songStream(song *common.Song) *common.StreamInfo {
+	songStreamMtx.Lock()
+	defer songStreamMtx.Unlock()
+	return song.Stream
+}
+
+// keep resolved stream with the song to reuse it until it expires
+func setSongStream(song *common.Song, stream *common.StreamInfo) {
+	songStreamMtx.Lock()
+	defer songStreamMtx.Unlock()
+	song.Stream = stream
+}
+
+// check if the stream was asked to seek or stop
+func (audioStream *AudioStreamSession) interrupted() bool {
+	audioStream.mtx.Lock()
+	defer audioStream.mtx.Unlock()
+	return audioStream.seeking || audioStream.stopped
+}
+
+// encode PCM frames with given encoder settings and send them to discord.
+// Settings received on encoderSettings are applied from the next frame
+func SendPCMPacket(logCtx string, voice *discordgo.VoiceConnection, settings EncoderSettings,
+	encoderSettings <-chan EncoderSettings, buf <-chan []int16) {
 	if buf == nil {
 		return
 	}
 
 	var err error
 
-	opusEncoder, err := gopus.NewEncoder(int(framerate), int(numChannels), gopus.Audio)
+	opusEncoder, err := newOpusEncoder(int(framerate), int(numChannels))
 
 	if err != nil {
 		log.Printf("%s NewEncoder Error: %s", logCtx, err.Error())
 		return
 	}
+	err = opusEncoder.configure(settings)
+	if err != nil {
+		log.Printf("%s %s", logCtx, err.Error())
+	}
 
 	for {
 
 		// read pcm from chan, exit if channel is closed.
-		recv, ok := <-buf
+		var recv []int16
+		var ok bool
+		select {
+		case settings = <-encoderSettings:
+			log.Printf("%s Configuring opus encoder with %+v", logCtx, settings)
+			err = opusEncoder.configure(settings)
+			if err != nil {
+				log.Printf("%s %s", logCtx, err.Error())
+			}
+			continue
+		case recv, ok = <-buf:
+		}
 		if !ok {
 			log.Printf("%s PCM Channel closed", logCtx)
 			return
 		}
 
 		// try encoding pcm frame with Opus
-		opus, err := opusEncoder.Encode(recv, int(framesize), int(maxBytes))
+		opus, err := opusEncoder.encode(recv, int(framesize), int(maxBytes))
 		if err != nil {
 			log.Printf("%s Encoding Error %s", logCtx, err.Error())
 			return
 		}
 
 		if voice.Ready == false || voice.OpusSend == nil {
-			// OnError(fmt.Sprintf("Discordgo not ready for opus packets. %+v : %+v", v.Ready, v.OpusSend), nil)
-			// Sending errors here might not be suited
-			return
+			// drop the frame but keep the pace so sources are not drained
+			time.Sleep(time.Duration(frameduration) * time.Millisecond)
+			continue
 		}
 		// send encoded opus data to the sendOpus channel
 		voice.OpusSend <- opus
 	}
 }
 
+// elapsed time of the song based on frames played. Time while paused, skipped
+// leading silence and frames not yet played are not counted
+func (audioStream *AudioStreamSession) elapsed() time.Duration {
+	audioStream.mtx.Lock()
+	defer audioStream.mtx.Unlock()
+	if audioStream.framesPlayed < audioStream.framesSkipped {
+		return 0
+	}
+	return framesToDuration(audioStream.framesPlayed - audioStream.framesSkipped)
+}
+
+// playback speed of the song. Elapsed time advances this many times faster
+// than real time
+func (audioStream *AudioStreamSession) playbackSpeed() float64 {
+	audioStream.mtx.Lock()
+	defer audioStream.mtx.Unlock()
+	return audioStream.speed
+}
+
+// seek ongoing stream to given position. Current source is closed and
+// restarted from the new position by stream()
+func (audioStream *AudioStreamSession) seekStream(position time.Duration) {
+	audioStream.mtx.Lock()
+	defer audioStream.mtx.Unlock()
+	audioStream.framesSent = durationToFrames(position)
+	audioStream.framesPlayed = audioStream.framesSent
+	audioStream.framesSkipped = 0
+	audioStream.seeking = true
+	if audioStream.closeSource != nil {
+		audioStream.closeSource()
+	}
+	audioStream.resumed.Broadcast()
+}
+
+// restart stream at current position to apply changed audio settings
+func (audioStream *AudioStreamSession) restartStream() {
+	audioStream.mtx.Lock()
+	defer audioStream.mtx.Unlock()
+	audioStream.seeking = true
+	if audioStream.closeSource != nil {
+		audioStream.closeSource()
+	}
+	audioStream.resumed.Broadcast()
+}
+
+// switch a passthrough stream to PCM for rest of the song, e.g. when it has
+// to be mixed with another source
+func (audioStream *AudioStreamSession) requirePCM() {
+	audioStream.mtx.Lock()
+	defer audioStream.mtx.Unlock()
+	audioStream.forcePCM = true
+	if audioStream.passthrough {
+		audioStream.seeking = true
+		audioStream.closeSource()
+		audioStream.resumed.Broadcast()
+	}
+}
+
+// restart a passthrough stream as PCM if changed settings need decoding
+func (audioStream *AudioStreamSession) applySettings() {
+	audioStream.mtx.Lock()
+	defer audioStream.mtx.Unlock()
+	if audioStream.passthrough && !audioStream.canPassthrough() {
+		audioStream.seeking = true
+		audioStream.closeSource()
+		audioStream.resumed.Broadcast()
+	}
+}
+
+// stop ongoing stream
+func (audioStream *AudioStreamSession) stopStream() {
+	audioStream.mtx.Lock()
+	defer audioStream.mtx.Unlock()
+	audioStream.stopped = true
+	if audioStream.closeSource != nil {
+		audioStream.closeSource()
+	}
+	audioStream.resumed.Broadcast()
+}
+
 // pause ongoing stream
 func (audioStream *AudioStreamSession) pauseStream() {
 	audioStream.mtx.Lock()
 	defer audioStream.mtx.Unlock()
-	audioStream.paused = true
+	if !audioStream.paused {
+		audioStream.paused = true
+		audioStream.pausedAt = time.Now()
+		audioStream.source.setPaused(true)
+	}
 }
 
-// resume ongoing stream
+// resume ongoing stream. Source is reopened at the paused position after a
+// long pause
 func (audioStream *AudioStreamSession) resumeStream() {
 	audioStream.mtx.Lock()
 	defer audioStream.mtx.Unlock()
+	if !audioStream.paused {
+		return
+	}
 	audioStream.paused = false
+	audioStream.source.setPaused(false)
+	if time.Since(audioStream.pausedAt) > reopenAfterPause && audioStream.closeSource != nil {
+		log.Printf("[%s(%s)]: Reopening stream after pause of %s", audioStream.song.SongTitle,
+			audioStream.song.SongId, time.Since(audioStream.pausedAt).Round(time.Second).String())
+		audioStream.seeking = true
+		audioStream.closeSource()
+	}
+	audioStream.resumed.Broadcast()
 }
@@ -0,0 +1,207 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package bot
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Ar5h71/r4-music-bot/common"
+	"github.com/bwmarrin/discordgo"
+)
+
+// max time a test waits for the stream
+const streamTestTimeout = 5 * time.Second
+
+// song played with the fake transcoder
+func fakeSong(songUrl string, frames int) *common.Song {
+	return &common.Song{
+		SongTitle:    songUrl,
+		SongId:       songUrl,
+		SongDuration: framesToDuration(frames),
+		Stream:       &common.StreamInfo{Url: songUrl, MimeType: "audio/mp4"},
+	}
+}
+
+// voice connection which takes opus packets without sending them
+func fakeVoiceConnection(t *testing.T) *discordgo.VoiceConnection {
+	voice := &discordgo.VoiceConnection{Ready: true, OpusSend: make(chan []byte, 16)}
+	done := make(chan interface{})
+	t.Cleanup(func() {
+		close(done)
+	})
+	go func() {
+		for {
+			select {
+			case <-voice.OpusSend:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return voice
+}
+
+// start a stream session of a song whose frames are read by the test from its
+// mixer source
+func startTestStream(t *testing.T, song *common.Song) (*AudioStreamSession, chan error) {
+	voice := fakeVoiceConnection(t)
+	source := newMixerSource(PriorityMusic, 1)
+	// mixer isn't run, the test takes frames of the source
+	NewMixer("[test]", voice, EncoderSettings{}).attachSource(source, 0)
+	done := make(chan error, 1)
+	audioStream := NewAudioStream(song, source, voice, NewAudioSettings(), nil, nil, done)
+	t.Cleanup(func() {
+		audioStream.stopStream()
+		source.remove()
+	})
+	return audioStream, done
+}
+
+// read next frame of a stream. Returns index of the frame in the song, or -1
+// if the stream has ended
+func readTestFrame(t *testing.T, audioStream *AudioStreamSession) int {
+	t.Helper()
+	select {
+	case frame, ok := <-audioStream.source.frames:
+		if !ok {
+			return -1
+		}
+		return int(frame[0]) - 1
+	case <-time.After(streamTestTimeout):
+		t.Fatalf("timed out waiting for a frame")
+		return -1
+	}
+}
+
+// read frames already sent by a stream which has stopped sending. Returns
+// index of the last frame read, or last if there was none
+func drainTestFrames(audioStream *AudioStreamSession, last int) int {
+	for {
+		select {
+		case frame, ok := <-audioStream.source.frames:
+			if !ok {
+				return last
+			}
+			last = int(frame[0]) - 1
+		case <-time.After(100 * time.Millisecond):
+			return last
+		}
+	}
+}
+
+func waitTestDone(t *testing.T, done chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(streamTestTimeout):
+		t.Fatalf("timed out waiting for stream to end")
+		return nil
+	}
+}
+
+func TestAudioStreamPlaysSong(t *testing.T) {
+	fake := newFakeTranscoder(map[string]int{"test://song": 20})
+	useTranscoder(t, fake)
+	audioStream, done := startTestStream(t, fakeSong("test://song", 20))
+
+	for want := 0; want < 20; want++ {
+		if frame := readTestFrame(t, audioStream); frame != want {
+			t.Fatalf("got frame %d, want %d", frame, want)
+		}
+	}
+	if frame := readTestFrame(t, audioStream); frame != -1 {
+		t.Fatalf("got frame %d after end of song", frame)
+	}
+	if err := waitTestDone(t, done); err != io.EOF {
+		t.Fatalf("stream ended with %v, want EOF", err)
+	}
+	if elapsed := audioStream.elapsed(); elapsed != framesToDuration(20) {
+		t.Fatalf("elapsed = %s, want %s", elapsed, framesToDuration(20))
+	}
+	if opens := fake.getOpens(); len(opens) != 1 || opens[0].offset != 0 {
+		t.Fatalf("song opened as %+v, want once from start", opens)
+	}
+}
+
+func TestAudioStreamPauseResume(t *testing.T) {
+	useTranscoder(t, newFakeTranscoder(map[string]int{"test://song": 500}))
+	audioStream, _ := startTestStream(t, fakeSong("test://song", 500))
+
+	for want := 0; want < 5; want++ {
+		if frame := readTestFrame(t, audioStream); frame != want {
+			t.Fatalf("got frame %d, want %d", frame, want)
+		}
+	}
+	audioStream.pauseStream()
+	// frames sent before the pause are still buffered
+	last := drainTestFrames(audioStream, 4)
+	elapsed := audioStream.elapsed()
+	if last > 8 {
+		t.Fatalf("stream sent frames till %d after pause at frame 4", last)
+	}
+	if frame := drainTestFrames(audioStream, -1); frame != -1 {
+		t.Fatalf("got frame %d while paused", frame)
+	}
+	if audioStream.elapsed() != elapsed {
+		t.Fatalf("elapsed time advanced while paused")
+	}
+
+	audioStream.resumeStream()
+	if frame := readTestFrame(t, audioStream); frame != last+1 {
+		t.Fatalf("got frame %d after resume, want %d", frame, last+1)
+	}
+}
+
+func TestAudioStreamSeek(t *testing.T) {
+	fake := newFakeTranscoder(map[string]int{"test://song": 500})
+	useTranscoder(t, fake)
+	audioStream, _ := startTestStream(t, fakeSong("test://song", 500))
+
+	readTestFrame(t, audioStream)
+	position := framesToDuration(200)
+	audioStream.seekStream(position)
+	if elapsed := audioStream.elapsed(); elapsed != position {
+		t.Fatalf("elapsed = %s after seek, want %s", elapsed, position)
+	}
+	// frames sent before the seek may still be buffered
+	frame := readTestFrame(t, audioStream)
+	for frame >= 0 && frame < 200 {
+		frame = readTestFrame(t, audioStream)
+	}
+	if frame != 200 {
+		t.Fatalf("got frame %d after seek, want 200", frame)
+	}
+	if frame := readTestFrame(t, audioStream); frame != 201 {
+		t.Fatalf("got frame %d, want 201", frame)
+	}
+	opens := fake.getOpens()
+	if len(opens) != 2 || opens[1].offset != position {
+		t.Fatalf("song opened as %+v, want reopen at %s", opens, position)
+	}
+}
+
+func TestAudioStreamStop(t *testing.T) {
+	useTranscoder(t, newFakeTranscoder(map[string]int{"test://song": 500}))
+	audioStream, done := startTestStream(t, fakeSong("test://song", 500))
+
+	readTestFrame(t, audioStream)
+	audioStream.stopStream()
+	// frames sent before the stop are still buffered, after which the source
+	// is closed
+	frames := 0
+	for frame := readTestFrame(t, audioStream); frame >= 0; frame = readTestFrame(t, audioStream) {
+		frames++
+		if frames > 5 {
+			t.Fatalf("stream kept sending frames after stop")
+		}
+	}
+	if err := waitTestDone(t, done); err != nil {
+		t.Fatalf("stopped stream ended with %v, want nil", err)
+	}
+}
@@ -0,0 +1,286 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package bot
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// audio settings for a guild. Settings persist across tracks in the queue
+type AudioSettings struct {
+	mtx sync.Mutex
+
+	// volume in percent, 100 being the original volume
+	volume int
+	// names of active filter presets
+	filters map[string]bool
+	// playback speed factor and pitch shift in semitones
+	speed float64
+	pitch int
+	// skip silence at start and end of songs
+	trimSilence bool
+	// duration for which consecutive songs are crossfaded
+	crossfade time.Duration
+	// normalize loudness of songs to target loudness in LUFS
+	normalize      bool
+	targetLoudness int
+	// opus encoder bitrate in kbps, 0 to match bitrate of the voice channel
+	bitrateKbps int
+	// in-band forward error correction and expected packet loss in percent
+	fec               bool
+	packetLossPercent int
+	// equalizer gain of each band in dB and name of the curve
+	eqGains [eqBands]float64
+	eqCurve string
+}
+
+// settings of the opus encoder used by the mixer
+type EncoderSettings struct {
+	BitrateKbps       int
+	Vbr               bool
+	Complexity        int
+	Fec               bool
+	PacketLossPercent int
+}
+
+const (
+	DefaultVolume = 100
+	MinVolume     = 0
+	MaxVolume     = 200
+	// max crossfade between songs in seconds
+	MaxCrossfadeSeconds = 12
+	// bitrate range of discord voice channels in kbps
+	MinBitrateKbps = 8
+	MaxBitrateKbps = 384
+	// packet loss expected by the encoder in percent
+	DefaultPacketLossPercent = 10
+	MaxPacketLossPercent     = 100
+	// max change of gain in a single frame to avoid clicks on volume change
+	volumeRampStep = 0.05
+)
+
+func NewAudioSettings() *AudioSettings {
+	return &AudioSettings{
+		volume:            DefaultVolume,
+		filters:           make(map[string]bool),
+		speed:             1,
+		targetLoudness:    DefaultTargetLoudness,
+		fec:               true,
+		packetLossPercent: DefaultPacketLossPercent,
+		eqCurve:           EqCurveFlat,
+	}
+}
+
+func (settings *AudioSettings) getVolume() int {
+	settings.mtx.Lock()
+	defer settings.mtx.Unlock()
+	return settings.volume
+}
+
+func (settings *AudioSettings) setVolume(volume int) {
+	settings.mtx.Lock()
+	defer settings.mtx.Unlock()
+	settings.volume = volume
+}
+
+func (settings *AudioSettings) getCrossfade() time.Duration {
+	settings.mtx.Lock()
+	defer settings.mtx.Unlock()
+	return settings.crossfade
+}
+
+func (settings *AudioSettings) setCrossfade(crossfade time.Duration) {
+	settings.mtx.Lock()
+	defer settings.mtx.Unlock()
+	settings.crossfade = crossfade
+}
+
+// loudness normalization state and target loudness in LUFS
+func (settings *AudioSettings) getNormalization() (bool, int) {
+	settings.mtx.Lock()
+	defer settings.mtx.Unlock()
+	return settings.normalize, settings.targetLoudness
+}
+
+func (settings *AudioSettings) setNormalization(normalize bool, targetLoudness int) {
+	settings.mtx.Lock()
+	defer settings.mtx.Unlock()
+	settings.normalize = normalize
+	settings.targetLoudness = targetLoudness
+}
+
+// encoder bitrate override in kbps, FEC and expected packet loss
+func (settings *AudioSettings) getEncoderQuality() (int, bool, int) {
+	settings.mtx.Lock()
+	defer settings.mtx.Unlock()
+	return settings.bitrateKbps, settings.fec, settings.packetLossPercent
+}
+
+func (settings *AudioSettings) setEncoderQuality(bitrateKbps int, fec bool, packetLossPercent int) {
+	settings.mtx.Lock()
+	defer settings.mtx.Unlock()
+	settings.bitrateKbps = bitrateKbps
+	settings.fec = fec
+	settings.packetLossPercent = packetLossPercent
+}
+
+// opus encoder settings for a voice channel with given bitrate in kbps
+func (settings *AudioSettings) encoderSettings(channelBitrateKbps int) EncoderSettings {
+	settings.mtx.Lock()
+	defer settings.mtx.Unlock()
+	bitrateKbps := settings.bitrateKbps
+	if bitrateKbps == 0 {
+		bitrateKbps = channelBitrateKbps
+	}
+	return EncoderSettings{
+		BitrateKbps:       bitrateKbps,
+		Vbr:               vbr,
+		Complexity:        compressionLevel,
+		Fec:               settings.fec,
+		PacketLossPercent: settings.packetLossPercent,
+	}
+}
+
+// equalizer gains of each band in dB and name of the curve
+func (settings *AudioSettings) getEqualizer() ([eqBands]float64, string) {
+	settings.mtx.Lock()
+	defer settings.mtx.Unlock()
+	return settings.eqGains, settings.eqCurve
+}
+
+// set all equalizer bands from a saved curve
+func (settings *AudioSettings) setEqCurve(name string) {
+	settings.mtx.Lock()
+	defer settings.mtx.Unlock()
+	settings.eqGains = eqCurves[name]
+	settings.eqCurve = name
+}
+
+// set gain of a single equalizer band in dB
+func (settings *AudioSettings) setEqBand(band int, gainDb float64) {
+	settings.mtx.Lock()
+	defer settings.mtx.Unlock()
+	settings.eqGains[band] = gainDb
+	settings.eqCurve = EqCurveCustom
+}
+
+// check if settings allow sending opus packets of a song without decoding
+// them to PCM
+func (settings *AudioSettings) passthroughAllowed() bool {
+	settings.mtx.Lock()
+	defer settings.mtx.Unlock()
+	return settings.volume == DefaultVolume && len(settings.filters) == 0 && settings.crossfade == 0 &&
+		!settings.normalize && settings.eqGains == eqCurves[EqCurveFlat] && settings.speed == 1 &&
+		settings.pitch == 0 && !settings.trimSilence
+}
+
+// toggle a filter preset. Returns true if the filter is now active
+func (settings *AudioSettings) toggleFilter(name string) bool {
+	settings.mtx.Lock()
+	defer settings.mtx.Unlock()
+	if settings.filters[name] {
+		delete(settings.filters, name)
+		return false
+	}
+	settings.filters[name] = true
+	return true
+}
+
+func (settings *AudioSettings) clearFilters() {
+	settings.mtx.Lock()
+	defer settings.mtx.Unlock()
+	settings.filters = make(map[string]bool)
+}
+
+// names of active filters in the order they are applied
+func (settings *AudioSettings) activeFilters() []string {
+	settings.mtx.Lock()
+	defer settings.mtx.Unlock()
+	var names []string
+	for _, preset := range filterPresets {
+		if settings.filters[preset.Name] {
+			names = append(names, preset.Name)
+		}
+	}
+	return names
+}
+
+func (settings *AudioSettings) setSpeed(speed float64) {
+	settings.mtx.Lock()
+	defer settings.mtx.Unlock()
+	settings.speed = speed
+}
+
+func (settings *AudioSettings) setPitch(pitch int) {
+	settings.mtx.Lock()
+	defer settings.mtx.Unlock()
+	settings.pitch = pitch
+}
+
+func (settings *AudioSettings) getTrimSilence() bool {
+	settings.mtx.Lock()
+	defer settings.mtx.Unlock()
+	return settings.trimSilence
+}
+
+func (settings *AudioSettings) setTrimSilence(trimSilence bool) {
+	settings.mtx.Lock()
+	defer settings.mtx.Unlock()
+	settings.trimSilence = trimSilence
+}
+
+// ffmpeg filter chain and playback speed factor for current settings
+func (settings *AudioSettings) filterChain() (string, float64) {
+	settings.mtx.Lock()
+	defer settings.mtx.Unlock()
+	return buildFilterChain(settings.filters, settings.speed, settings.pitch)
+}
+
+// scales PCM samples and ramps the gain smoothly towards the target volume
+type volumeScaler struct {
+	gain float64
+}
+
+func newVolumeScaler(gain float64) *volumeScaler {
+	return &volumeScaler{
+		gain: gain,
+	}
+}
+
+// scale interleaved PCM frame in place towards target linear gain
+func (scaler *volumeScaler) scale(pcm []int16, target float64) {
+	if scaler.gain == target && target == 1 {
+		return
+	}
+	// limit gain change for this frame and ramp linearly over the samples
+	start := scaler.gain
+	end := target
+	if end > start+volumeRampStep {
+		end = start + volumeRampStep
+	} else if end < start-volumeRampStep {
+		end = start - volumeRampStep
+	}
+	samplesPerChannel := len(pcm) / numChannels
+	for idx := range pcm {
+		pos := float64(idx/numChannels) / float64(samplesPerChannel)
+		gain := start + (end-start)*pos
+		pcm[idx] = clipSample(float64(pcm[idx]) * gain)
+	}
+	scaler.gain = end
+}
+
+// round and clip a sample to int16 range
+func clipSample(sample float64) int16 {
+	if sample > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	if sample < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(math.Round(sample))
+}
@@ -8,8 +8,10 @@ E-mail: ad.sigh.arsh@gmail.com
 package bot
 
 import (
+	"fmt"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/Ar5h71/r4-music-bot/common"
 	"github.com/bwmarrin/discordgo"
@@ -24,6 +26,8 @@ type BotInstance struct {
 	TextChannelId      string
 	Speaking           bool
 	Queue              *BotQueue
+	AudioSettings      *AudioSettings
+	Mixer              *Mixer
 	// add for queue and current playing song
 }
 
@@ -33,12 +37,22 @@ type BotQueue struct {
 
 	songs      []*common.Song
 	paused     bool
+	running    bool
 	nowPlaying *NowPlaying
+	// next song decoded in advance to avoid gaps between songs
+	prefetched *NowPlaying
 	skip       chan interface{}
 	stop       chan interface{}
 	pause      chan interface{}
 	resume     chan interface{}
+	seek       chan time.Duration
+	prefetch   chan *AudioStreamSession
+	nearEnd    chan *AudioStreamSession
+	next       chan interface{}
 	done       chan interface{}
+	// sound effects interrupting the song. Songs are not passed through
+	// while any of them plays, so that the mixer can hold them
+	interruptions int
 }
 
 type NowPlaying struct {
@@ -49,7 +63,9 @@ type NowPlaying struct {
 // to send signal in a channel to play a song for an instance
 // thread is initiated in StartBot()
 type SongSignal struct {
-	song        *common.Song
+	song *common.Song
+	// songs queued right after song, like rest of a playlist
+	more        []*common.Song
 	botInstance *BotInstance
 	playNow     bool
 }
@@ -72,6 +88,10 @@ func NewBotInstance(session *discordgo.Session,
 		log.Printf("[%s | %s] Failed to create voice connection. Got error: %s", guildId, vchannelId, err.Error())
 		return nil, err
 	}
+	audioSettings := NewAudioSettings()
+	encoderSettings := audioSettings.encoderSettings(voiceChannelBitrateKbps(session, vchannelId))
+	mixer := NewMixer(fmt.Sprintf("[%s | %s]", guildId, vchannelId), voiceConnection, encoderSettings)
+	go mixer.run()
 	return &BotInstance{
 		BotSession:         session,
 		GuildId:            guildId,
@@ -79,18 +99,43 @@ func NewBotInstance(session *discordgo.Session,
 		TextChannelId:      tChannelId,
 		Speaking:           speaking,
 		BotVoiceConnection: voiceConnection,
-		Queue: &BotQueue{
-			paused: false,
-			stop:   make(chan interface{}, 1),
-			done:   make(chan interface{}, 1),
-			skip:   make(chan interface{}, 1),
-			pause:  make(chan interface{}, 1),
-			resume: make(chan interface{}, 1),
-			songs:  make([]*common.Song, 0),
-		},
+		AudioSettings:      audioSettings,
+		Mixer:              mixer,
+		Queue:              newBotQueue(),
 	}, nil
 }
 
+// create an empty queue
+func newBotQueue() *BotQueue {
+	return &BotQueue{
+		paused:   false,
+		stop:     make(chan interface{}, 1),
+		done:     make(chan interface{}, 1),
+		skip:     make(chan interface{}, 1),
+		pause:    make(chan interface{}, 1),
+		resume:   make(chan interface{}, 1),
+		seek:     make(chan time.Duration, 1),
+		prefetch: make(chan *AudioStreamSession, 1),
+		nearEnd:  make(chan *AudioStreamSession, 1),
+		next:     make(chan interface{}, 1),
+		songs:    make([]*common.Song, 0),
+	}
+}
+
+// bitrate of a voice channel in kbps. Falls back to default bitrate if channel
+// can't be fetched
+func voiceChannelBitrateKbps(session *discordgo.Session, vchannelId string) int {
+	channel, err := session.State.Channel(vchannelId)
+	if err != nil {
+		channel, err = session.Channel(vchannelId)
+	}
+	if err != nil || channel.Bitrate == 0 {
+		log.Printf("Failed to get bitrate of voice channel '%s', using %dkbps", vchannelId, audioBitRateKbps)
+		return audioBitRateKbps
+	}
+	return channel.Bitrate / 1000
+}
+
 // Create and open bot session and voice connection
 func StartBot(botToken string) error {
 	log.Printf("Initializing bot session.")
@@ -110,6 +155,10 @@ func StartBot(botToken string) error {
 			if handler, ok := commandHandlers[interaction.ApplicationCommandData().Name]; ok {
 				handler(session, interaction)
 			}
+		case discordgo.InteractionApplicationCommandAutocomplete:
+			if handler, ok := autocompleteHandlers[interaction.ApplicationCommandData().Name]; ok {
+				handler(session, interaction)
+			}
 		case discordgo.InteractionMessageComponent:
 			if handler, ok := componentHandlers[interaction.MessageComponentData().CustomID]; ok {
 				handler(session, interaction)
@@ -189,6 +238,7 @@ func StopBotInstance(botInstance *BotInstance) {
 	// disconnect from voice
 	log.Printf("disconnecting bot")
 	botInstance.Queue.stop <- nil
+	botInstance.Mixer.stopMixer()
 	botInstance.BotVoiceConnection.Disconnect()
 	// remove botInstance from the map
 	delete(BotInstances, botInstance.GuildId)
@@ -0,0 +1,118 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package bot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// magic bytes at the start of a DCA1 file. Files without it are legacy DCA0
+// files which only contain opus frames
+const dcaMagic = "DCA1"
+
+// metadata header of a DCA1 file
+type DCAMetadata struct {
+	Dca struct {
+		Version int `json:"version"`
+		Tool    struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+			Url     string `json:"url"`
+			Author  string `json:"author"`
+		} `json:"tool"`
+	} `json:"dca"`
+	Info struct {
+		Title   string `json:"title"`
+		Artist  string `json:"artist"`
+		Album   string `json:"album"`
+		Genre   string `json:"genre"`
+		Comment string `json:"comments"`
+	} `json:"info"`
+	Opus struct {
+		Mode       string `json:"mode"`
+		SampleRate int    `json:"sample_rate"`
+		FrameSize  int    `json:"frame_size"`
+		Abr        int    `json:"abr"`
+		Channels   int    `json:"channels"`
+	} `json:"opus"`
+}
+
+// reads opus frames from a DCA file. Each frame is prefixed with its length as
+// a little endian int16
+type DCAReader struct {
+	reader   *bufio.Reader
+	Metadata *DCAMetadata
+}
+
+func NewDCAReader(reader io.Reader) (*DCAReader, error) {
+	dca := &DCAReader{
+		reader: bufio.NewReader(reader),
+	}
+	magic, err := dca.reader.Peek(len(dcaMagic))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read DCA header. Error: %s", err.Error())
+	}
+	if !bytes.Equal(magic, []byte(dcaMagic)) {
+		// legacy DCA0 file without metadata
+		return dca, nil
+	}
+	dca.reader.Discard(len(dcaMagic))
+
+	var metadataLen int32
+	err = binary.Read(dca.reader, binary.LittleEndian, &metadataLen)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read DCA metadata size. Error: %s", err.Error())
+	}
+	if metadataLen < 0 {
+		return nil, fmt.Errorf("Invalid DCA metadata size %d", metadataLen)
+	}
+	metadataBuf := make([]byte, metadataLen)
+	_, err = io.ReadFull(dca.reader, metadataBuf)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read DCA metadata. Error: %s", err.Error())
+	}
+	dca.Metadata = &DCAMetadata{}
+	err = json.Unmarshal(metadataBuf, dca.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse DCA metadata. Error: %s", err.Error())
+	}
+	return dca, nil
+}
+
+// number of channels of the opus frames. Legacy files are always stereo
+func (dca *DCAReader) Channels() int {
+	if dca.Metadata == nil || dca.Metadata.Opus.Channels == 0 {
+		return numChannels
+	}
+	return dca.Metadata.Opus.Channels
+}
+
+// read next opus frame. Returns io.EOF when there are no more frames
+func (dca *DCAReader) ReadFrame() ([]byte, error) {
+	var frameLen int16
+	err := binary.Read(dca.reader, binary.LittleEndian, &frameLen)
+	if err != nil {
+		// io.EOF if file ended at frame boundary
+		return nil, err
+	}
+	if frameLen <= 0 {
+		return nil, fmt.Errorf("Invalid DCA frame size %d", frameLen)
+	}
+	frame := make([]byte, frameLen)
+	_, err = io.ReadFull(dca.reader, frame)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return frame, nil
+}
@@ -8,8 +8,11 @@ package bot
 import (
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/Ar5h71/r4-music-bot/common"
+	"github.com/Ar5h71/r4-music-bot/musicmanager"
 	"github.com/bwmarrin/discordgo"
 )
 
@@ -24,17 +27,30 @@ func SearchVoiceChannelId(userId string) string {
 	return ""
 }
 
-// send 'adding to queue' message
-func addToQueueInteractionResponse(session *discordgo.Session, interaction *discordgo.InteractionCreate, song *common.Song, playNow bool) error {
+// title and channel of a song. Youtube songs link to their video and channel,
+// local songs show their artist
+func songTitleAndChannel(song *common.Song) string {
+	if song.Source != musicmanager.YoutubeSourceName {
+		artist := song.ChannelName
+		if artist == "" {
+			artist = "Unknown artist"
+		}
+		return fmt.Sprintf("`%s` | `%s`", song.SongTitle, artist)
+	}
 	videoUrl := common.YoutubeVideoURLPrefix + song.SongId
 	channelUrl := common.YoutubeChannelURLPrefix + song.ChannelId
+	return fmt.Sprintf("[%s](<%s>) | [%s](<%s>)", song.SongTitle, videoUrl, song.ChannelName, channelUrl)
+}
+
+// send 'adding to queue' message
+func addToQueueInteractionResponse(session *discordgo.Session, interaction *discordgo.InteractionCreate, song *common.Song, playNow bool) error {
 	var msg string
 	if playNow {
-		msg = fmt.Sprintf(">>> **Adding to Queue Top** \n\n`%s` -- [%s](<%s>) | [%s](<%s>) | Requested by -- `%s`",
-			song.SongDuration.String(), song.SongTitle, videoUrl, song.ChannelName, channelUrl, song.User)
+		msg = fmt.Sprintf(">>> **Adding to Queue Top** \n\n`%s` -- %s | Requested by -- `%s`",
+			song.SongDuration.String(), songTitleAndChannel(song), song.User)
 	} else {
-		msg = fmt.Sprintf(">>> **Adding to Queue** \n\n`%s` -- [%s](<%s>) | [%s](<%s>) | Requested by -- `%s`",
-			song.SongDuration.String(), song.SongTitle, videoUrl, song.ChannelName, channelUrl, song.User)
+		msg = fmt.Sprintf(">>> **Adding to Queue** \n\n`%s` -- %s | Requested by -- `%s`",
+			song.SongDuration.String(), songTitleAndChannel(song), song.User)
 	}
 	_, err := session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
 		Content: &msg,
@@ -45,6 +61,45 @@ func addToQueueInteractionResponse(session *discordgo.Session, interaction *disc
 	return err
 }
 
+// summary of songs added from a playlist
+func addPlaylistToQueueInteractionResponse(session *discordgo.Session, interaction *discordgo.InteractionCreate,
+	playlist *musicmanager.Playlist, playNow bool) error {
+	header := "Adding Playlist to Queue"
+	if playNow {
+		header = "Adding Playlist to Queue Top"
+	}
+	title := playlist.Title
+	if title == "" {
+		title = "Untitled playlist"
+	}
+	msg := fmt.Sprintf(">>> **%s** \n\n`%s` | Added `%d` songs", header, title, len(playlist.Songs))
+	if playlist.Unavailable > 0 {
+		msg += fmt.Sprintf(" | `%d` unavailable", playlist.Unavailable)
+	}
+	msg += fmt.Sprintf(" | Requested by -- `%s`", playlist.Songs[0].User)
+	_, err := session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+		Content: &msg,
+	})
+	if err != nil {
+		log.Printf("Failed to send interaction response for add playlist to queue. Got error: %s", err.Error())
+	}
+	return err
+}
+
+// entries and hit rate of youtube metadata cache
+func generateCacheStatsMessage(stats musicmanager.CacheStats) string {
+	hitRate := func(hits, misses int) float64 {
+		if hits+misses == 0 {
+			return 0
+		}
+		return 100 * float64(hits) / float64(hits+misses)
+	}
+	return fmt.Sprintf(">>> **Metadata Cache** \n\nSearches: `%d` cached | `%d` hits | `%d` misses | `%.0f%%` hit rate\n"+
+		"Videos: `%d` cached | `%d` hits | `%d` misses | `%.0f%%` hit rate",
+		stats.SearchEntries, stats.SearchHits, stats.SearchMisses, hitRate(stats.SearchHits, stats.SearchMisses),
+		stats.VideoEntries, stats.VideoHits, stats.VideoMisses, hitRate(stats.VideoHits, stats.VideoMisses))
+}
+
 // send 'current playing song' message
 func sendCurrentPlayingSongMessage(botInstance *BotInstance, song *common.Song) {
 	msg := fmt.Sprintf(">>> **Playing** \n\n`%s` -- `%s` | `%s` | Requested by -- `%s`",
@@ -57,6 +112,64 @@ func sendCurrentPlayingSongMessage(botInstance *BotInstance, song *common.Song)
 	}
 }
 
+// length of progress bar in now playing message
+const progressBarLength = 20
+
+// generate 'now playing' message with elapsed time and a progress bar. Time
+// left is in real time at given playback speed
+func generateNowPlayingMessage(song *common.Song, elapsed time.Duration, speed float64, paused bool) string {
+	header := "Now Playing"
+	if paused {
+		header = "Paused"
+	}
+	msg := fmt.Sprintf(">>> **%s** \n\n%s | Requested by -- `%s`\n\n",
+		header, songTitleAndChannel(song), song.User)
+	if song.SongDuration == 0 {
+		// duration of live streams is not known
+		return msg + fmt.Sprintf("`%s`", common.FormatTimestamp(elapsed))
+	}
+	if elapsed > song.SongDuration {
+		elapsed = song.SongDuration
+	}
+	msg += fmt.Sprintf("`%s` %s `%s`", common.FormatTimestamp(elapsed),
+		generateProgressBar(elapsed, song.SongDuration), common.FormatTimestamp(song.SongDuration))
+	if speed <= 0 {
+		speed = 1
+	}
+	timeLeft := time.Duration(float64(song.SongDuration-elapsed) / speed)
+	if speed != 1 {
+		msg += fmt.Sprintf(" | `%.2fx`", speed)
+	}
+	return msg + fmt.Sprintf(" | Ends in `%s`", common.FormatTimestamp(timeLeft))
+}
+
+// text progress bar with a marker at elapsed time
+func generateProgressBar(elapsed, total time.Duration) string {
+	marker := int(float64(elapsed) / float64(total) * progressBarLength)
+	if marker >= progressBarLength {
+		marker = progressBarLength - 1
+	}
+	return strings.Repeat("▬", marker) + "🔘" + strings.Repeat("▬", progressBarLength-marker-1)
+}
+
+// generate message with gain of each equalizer band
+func generateEqualizerMessage(gains [eqBands]float64, curve string) string {
+	msg := fmt.Sprintf(">>> **Equalizer** -- `%s`\n\n", curve)
+	for band, gain := range gains {
+		msg += fmt.Sprintf("`%6s %+3.0fdB` %s\n", formatFrequency(eqFrequencies[band]), gain,
+			strings.Repeat("▮", int(gain-MinEqGainDb)/2+1))
+	}
+	return msg
+}
+
+// format a frequency like '31Hz' or '16kHz'
+func formatFrequency(frequency int) string {
+	if frequency >= 1000 {
+		return fmt.Sprintf("%dkHz", frequency/1000)
+	}
+	return fmt.Sprintf("%dHz", frequency)
+}
+
 // send any message to discord channel
 func sendMessageToChannel(botInstance *BotInstance, msg string) {
 	_, err := botInstance.BotSession.ChannelMessageSend(botInstance.TextChannelId, msg)
@@ -92,6 +205,11 @@ func sendSearchResultsContentAndSelect(session *discordgo.Session, interaction *
 	msg := ">>> **Search Results\n\n**"
 	if len(songs) > 1 {
 		for idx, song := range songs {
+			if song.Source != musicmanager.YoutubeSourceName {
+				msg += fmt.Sprintf("%d. `%s` -- `%s` (library) \n",
+					idx+1, song.SongDuration.String(), common.ShortenSongTitle(song.SongTitle))
+				continue
+			}
 			videoUrl := fmt.Sprintf("%s%s", common.YoutubeVideoURLPrefix, song.SongId)
 			msg += fmt.Sprintf("%d. `%s` -- [%s](<%s>) \n",
 				idx+1, song.SongDuration.String(), common.ShortenSongTitle(song.SongTitle), videoUrl)
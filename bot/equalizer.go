@@ -0,0 +1,150 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package bot
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	eqBands = 10
+	// gain range of each band in dB
+	MinEqGainDb = -12
+	MaxEqGainDb = 12
+	// quality factor of band filters, roughly one octave wide
+	eqBandQ = 1.41
+	// name of curve when bands are set individually
+	EqCurveCustom = "custom"
+	EqCurveFlat   = "flat"
+)
+
+// ISO centre frequencies of equalizer bands in Hz
+var eqFrequencies = [eqBands]int{31, 62, 125, 250, 500, 1000, 2000, 4000, 8000, 16000}
+
+// saved equalizer curves with gain of each band in dB
+var eqCurves = map[string][eqBands]float64{
+	EqCurveFlat:  {0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+	"bass":       {6, 5, 4, 2, 0, 0, 0, 0, 0, 0},
+	"treble":     {0, 0, 0, 0, 0, 1, 3, 5, 6, 7},
+	"rock":       {5, 4, 3, 1, -1, -1, 1, 3, 4, 5},
+	"pop":        {-1, 0, 2, 4, 5, 4, 2, 0, -1, -1},
+	"vocal":      {-3, -3, -2, 0, 3, 5, 5, 3, 1, 0},
+	"classical":  {4, 3, 2, 1, 0, 0, 0, 2, 3, 4},
+	"electronic": {5, 4, 1, 0, -2, 1, 0, 1, 4, 5},
+}
+
+// names of saved equalizer curves in sorted order
+func eqCurveNames() []string {
+	names := make([]string, 0, len(eqCurves))
+	for name := range eqCurves {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// index of the band with given centre frequency
+func eqBandIndex(frequency int) (int, bool) {
+	for idx, bandFrequency := range eqFrequencies {
+		if bandFrequency == frequency {
+			return idx, true
+		}
+	}
+	return 0, false
+}
+
+// graphic equalizer of peaking filters applied to interleaved stereo PCM.
+// Holds filter state of a stream, so each stream needs its own equalizer
+type equalizer struct {
+	gains [eqBands]float64
+	// filters of each band for each channel
+	filters [eqBands][]*biquad
+	// last two samples of each channel, latest first, kept while the curve is
+	// flat and filters don't run
+	history [][2]float64
+}
+
+func newEqualizer() *equalizer {
+	eq := &equalizer{history: make([][2]float64, numChannels)}
+	for band := range eq.filters {
+		for channel := 0; channel < numChannels; channel++ {
+			eq.filters[band] = append(eq.filters[band], &biquad{coeffs: peakingCoeffs(eqFrequencies[band], 0)})
+		}
+	}
+	return eq
+}
+
+// coefficients of a peaking filter at given frequency and gain from RBJ audio
+// EQ cookbook
+func peakingCoeffs(frequency int, gainDb float64) biquadCoeffs {
+	amplitude := math.Pow(10, gainDb/40)
+	w0 := 2 * math.Pi * float64(frequency) / float64(framerate)
+	alpha := math.Sin(w0) / (2 * eqBandQ)
+	a0 := 1 + alpha/amplitude
+	return biquadCoeffs{
+		b0: (1 + alpha*amplitude) / a0,
+		b1: -2 * math.Cos(w0) / a0,
+		b2: (1 - alpha*amplitude) / a0,
+		a1: -2 * math.Cos(w0) / a0,
+		a2: (1 - alpha/amplitude) / a0,
+	}
+}
+
+// apply band gains in dB to a PCM frame in place. Filters are updated when
+// gains change, keeping their state to avoid clicks. Flat curves leave the
+// frame untouched without running the filters
+func (eq *equalizer) process(pcm []int16, gains [eqBands]float64) {
+	flat := gains == eqCurves[EqCurveFlat]
+	if flat {
+		eq.gains = gains
+		eq.keepHistory(pcm)
+		return
+	}
+	if gains != eq.gains {
+		if eq.gains == eqCurves[EqCurveFlat] {
+			eq.resumeFilters()
+		}
+		for band := range gains {
+			if gains[band] == eq.gains[band] {
+				continue
+			}
+			for _, filter := range eq.filters[band] {
+				filter.coeffs = peakingCoeffs(eqFrequencies[band], gains[band])
+			}
+		}
+		eq.gains = gains
+	}
+	for idx, sample := range pcm {
+		channel := idx % numChannels
+		value := float64(sample)
+		for band := range eq.filters {
+			value = eq.filters[band][channel].process(value)
+		}
+		pcm[idx] = clipSample(value)
+	}
+}
+
+// keep last samples of a frame which isn't filtered
+func (eq *equalizer) keepHistory(pcm []int16) {
+	for channel := 0; channel < numChannels && len(pcm) >= 2*numChannels; channel++ {
+		last := len(pcm) - numChannels + channel
+		eq.history[channel] = [2]float64{float64(pcm[last]), float64(pcm[last-numChannels])}
+	}
+}
+
+// set filter state from samples played while the curve was flat. Flat filters
+// pass samples as they are, so this is the state they would have had if they
+// had been running
+func (eq *equalizer) resumeFilters() {
+	for band := range eq.filters {
+		for channel, filter := range eq.filters[band] {
+			history := eq.history[channel]
+			filter.x1, filter.x2 = history[0], history[1]
+			filter.y1, filter.y2 = history[0], history[1]
+		}
+	}
+}
@@ -0,0 +1,102 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package bot
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ffmpeg audio filter preset
+type FilterPreset struct {
+	Name        string
+	Description string
+	// ffmpeg '-af' filter chain for the preset
+	filter string
+	// playback speed factor introduced by the filter
+	speed float64
+}
+
+const (
+	// special filter name to remove all active filters
+	FilterOff = "off"
+	// playback speed range, within range of a single ffmpeg atempo filter
+	MinSpeed = 0.5
+	MaxSpeed = 2.0
+	// pitch shift range in semitones
+	MinPitch = -12
+	MaxPitch = 12
+)
+
+// presets in the order they are applied in the filter chain
+var filterPresets = []FilterPreset{
+	{
+		Name:        "bassboost",
+		Description: "Boost low frequencies",
+		filter:      "bass=g=10:f=110:w=0.6",
+		speed:       1,
+	},
+	{
+		Name:        "nightcore",
+		Description: "Faster with higher pitch",
+		filter:      "aresample=48000,asetrate=60000,aresample=48000",
+		speed:       1.25,
+	},
+	{
+		Name:        "vaporwave",
+		Description: "Slower with lower pitch",
+		filter:      "aresample=48000,asetrate=38400,aresample=48000",
+		speed:       0.8,
+	},
+	{
+		Name:        "8d",
+		Description: "Audio rotating around the listener",
+		filter:      "apulsator=hz=0.125",
+		speed:       1,
+	},
+	{
+		Name:        "karaoke",
+		Description: "Remove center panned vocals",
+		filter:      "stereotools=mlev=0.015625",
+		speed:       1,
+	},
+}
+
+func getFilterPreset(name string) (FilterPreset, bool) {
+	for _, preset := range filterPresets {
+		if preset.Name == strings.ToLower(name) {
+			return preset, true
+		}
+	}
+	return FilterPreset{}, false
+}
+
+// build ffmpeg filter chain and combined speed factor for active filters,
+// playback speed and pitch shift in semitones
+func buildFilterChain(activeFilters map[string]bool, speed float64, pitch int) (string, float64) {
+	var filters []string
+	speedFactor := 1.0
+	for _, preset := range filterPresets {
+		if !activeFilters[preset.Name] {
+			continue
+		}
+		filters = append(filters, preset.filter)
+		speedFactor *= preset.speed
+	}
+	if pitch != 0 {
+		// resample to shift pitch and stretch tempo back to original
+		ratio := math.Pow(2, float64(pitch)/12)
+		filters = append(filters, fmt.Sprintf("aresample=%d,asetrate=%d,aresample=%d,atempo=%.6f",
+			framerate, int(math.Round(float64(framerate)*ratio)), framerate, 1/ratio))
+	}
+	if speed != 1 {
+		// atempo keeps the pitch
+		filters = append(filters, fmt.Sprintf("atempo=%.3f", speed))
+		speedFactor *= speed
+	}
+	return strings.Join(filters, ","), speedFactor
+}
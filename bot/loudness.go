@@ -0,0 +1,236 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package bot
+
+import (
+	"math"
+	"sync"
+
+	"github.com/Ar5h71/r4-music-bot/common"
+)
+
+// loudness normalization constants in LUFS and dB
+const (
+	DefaultTargetLoudness = -14
+	MinTargetLoudness     = -30
+	MaxTargetLoudness     = -5
+	// max gain applied by normalization either way
+	maxNormalizationGainDb = 12.0
+	// gates of integrated loudness as per EBU R128
+	absoluteGateLufs = -70.0
+	relativeGateLu   = -10.0
+	// 400ms gating blocks made of 4 sub blocks of 100ms
+	loudnessSubBlockFrames = 5
+	loudnessBlockSubBlocks = 4
+	// gated blocks are counted in a histogram of loudness from the absolute
+	// gate up with bins of 0.1 LU, as in the BS.1770 reference meter
+	loudnessHistogramBinLu = 0.1
+	loudnessHistogramBins  = 800
+	// min measured audio before running estimate is used
+	minMeasuredSubBlocks = 30
+	// fraction of a song which has to be measured to cache its loudness
+	cacheMeasuredFraction = 0.9
+	// loudness which ReplayGain 2 track gain brings a song to
+	replayGainReferenceLufs = -18.0
+)
+
+// K-weighting filter coefficients for 48KHz from ITU-R BS.1770
+var (
+	kWeightShelf = biquadCoeffs{
+		b0: 1.53512485958697, b1: -2.69169618940638, b2: 1.19839281085285,
+		a1: -1.69065929318241, a2: 0.73248077421585,
+	}
+	kWeightHighPass = biquadCoeffs{
+		b0: 1.0, b1: -2.0, b2: 1.0,
+		a1: -1.99004745483398, a2: 0.99007225036621,
+	}
+)
+
+// integrated loudness of songs keyed by song id
+var (
+	loudnessCacheMtx sync.Mutex
+	loudnessCache    = make(map[string]float64)
+)
+
+func getCachedLoudness(songId string) (float64, bool) {
+	loudnessCacheMtx.Lock()
+	defer loudnessCacheMtx.Unlock()
+	loudness, ok := loudnessCache[songId]
+	return loudness, ok
+}
+
+// known loudness of a song from cache, or from its ReplayGain tag
+func songLoudness(song *common.Song) (float64, bool) {
+	if loudness, ok := getCachedLoudness(song.SongId); ok {
+		return loudness, true
+	}
+	if song.HasReplayGain {
+		return replayGainReferenceLufs - song.ReplayGain, true
+	}
+	return 0, false
+}
+
+func setCachedLoudness(songId string, loudness float64) {
+	loudnessCacheMtx.Lock()
+	defer loudnessCacheMtx.Unlock()
+	loudnessCache[songId] = loudness
+}
+
+type biquadCoeffs struct {
+	b0, b1, b2, a1, a2 float64
+}
+
+// direct form 1 biquad filter state for one channel
+type biquad struct {
+	coeffs         biquadCoeffs
+	x1, x2, y1, y2 float64
+}
+
+func (filter *biquad) process(x float64) float64 {
+	c := filter.coeffs
+	y := c.b0*x + c.b1*filter.x1 + c.b2*filter.x2 - c.a1*filter.y1 - c.a2*filter.y2
+	filter.x2, filter.x1 = filter.x1, x
+	filter.y2, filter.y1 = filter.y1, y
+	return y
+}
+
+// measures integrated loudness of interleaved stereo PCM as per EBU R128.
+// Blocks are kept as a histogram, so memory and time to get loudness don't
+// grow with length of the song
+type loudnessMeter struct {
+	shelf    []*biquad
+	highPass []*biquad
+
+	// sum of squares of K-weighted samples in current sub block
+	subBlockEnergy float64
+	subBlockFrames int
+	// mean square of last completed 100ms sub blocks, latest last
+	recentSubBlocks [loudnessBlockSubBlocks]float64
+	subBlockCount   int
+	// number of blocks above absolute gate and sum of their mean squares in
+	// each histogram bin
+	binBlocks [loudnessHistogramBins]int
+	binEnergy [loudnessHistogramBins]float64
+	// position in the song of the last measured frame. Audio played again,
+	// like after seeking back, is not measured twice
+	measuredUntil int
+}
+
+func newLoudnessMeter() *loudnessMeter {
+	meter := &loudnessMeter{}
+	for channel := 0; channel < numChannels; channel++ {
+		meter.shelf = append(meter.shelf, &biquad{coeffs: kWeightShelf})
+		meter.highPass = append(meter.highPass, &biquad{coeffs: kWeightHighPass})
+	}
+	return meter
+}
+
+// add an interleaved PCM frame ending at given position in the song to the
+// measurement. Returns true if a sub block was completed by the frame
+func (meter *loudnessMeter) addFrame(pcm []int16, position int) bool {
+	if position <= meter.measuredUntil {
+		return false
+	}
+	meter.measuredUntil = position
+	for idx, sample := range pcm {
+		channel := idx % numChannels
+		weighted := meter.highPass[channel].process(meter.shelf[channel].process(float64(sample) / math.MaxInt16))
+		meter.subBlockEnergy += weighted * weighted
+	}
+	meter.subBlockFrames++
+	if meter.subBlockFrames < loudnessSubBlockFrames {
+		return false
+	}
+	// channel weights are 1 for stereo, so mean square per channel is summed
+	copy(meter.recentSubBlocks[:], meter.recentSubBlocks[1:])
+	meter.recentSubBlocks[loudnessBlockSubBlocks-1] = meter.subBlockEnergy / float64(loudnessSubBlockFrames*framesize)
+	meter.subBlockCount++
+	meter.subBlockEnergy = 0
+	meter.subBlockFrames = 0
+	// 400ms blocks overlap by 75%, so a block ends with every sub block
+	if meter.subBlockCount >= loudnessBlockSubBlocks {
+		energy := 0.0
+		for _, subBlock := range meter.recentSubBlocks {
+			energy += subBlock
+		}
+		meter.addBlock(energy / loudnessBlockSubBlocks)
+	}
+	return true
+}
+
+// count a block in the histogram if it is above absolute gate
+func (meter *loudnessMeter) addBlock(energy float64) {
+	if energy <= energyFromLufs(absoluteGateLufs) {
+		return
+	}
+	bin := loudnessHistogramBin(lufsFromEnergy(energy))
+	meter.binBlocks[bin]++
+	meter.binEnergy[bin] += energy
+}
+
+// histogram bin of a loudness above absolute gate
+func loudnessHistogramBin(lufs float64) int {
+	bin := int((lufs - absoluteGateLufs) / loudnessHistogramBinLu)
+	if bin < 0 {
+		return 0
+	}
+	if bin >= loudnessHistogramBins {
+		return loudnessHistogramBins - 1
+	}
+	return bin
+}
+
+// duration of measured audio in frames
+func (meter *loudnessMeter) measuredFrames() int {
+	return meter.subBlockCount*loudnessSubBlockFrames + meter.subBlockFrames
+}
+
+// gated integrated loudness in LUFS. Returns false if not enough audio was
+// measured. Relative gate is applied at resolution of histogram bins
+func (meter *loudnessMeter) integrated() (float64, bool) {
+	if meter.subBlockCount < minMeasuredSubBlocks {
+		return 0, false
+	}
+	absoluteGated := meter.gatedMean(0)
+	if absoluteGated == 0 {
+		return 0, false
+	}
+	relativeGate := lufsFromEnergy(absoluteGated) + relativeGateLu
+	relativeGated := meter.gatedMean(loudnessHistogramBin(relativeGate))
+	if relativeGated == 0 {
+		return 0, false
+	}
+	return lufsFromEnergy(relativeGated), true
+}
+
+// mean of block energies in histogram bins from given bin up
+func (meter *loudnessMeter) gatedMean(fromBin int) float64 {
+	sum := 0.0
+	count := 0
+	for bin := fromBin; bin < loudnessHistogramBins; bin++ {
+		sum += meter.binEnergy[bin]
+		count += meter.binBlocks[bin]
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
+
+func lufsFromEnergy(energy float64) float64 {
+	return -0.691 + 10*math.Log10(energy)
+}
+
+func energyFromLufs(lufs float64) float64 {
+	return math.Pow(10, (lufs+0.691)/10)
+}
+
+// linear gain to bring a song with given loudness to target loudness
+func normalizationGain(loudness float64, target int) float64 {
+	gainDb := float64(target) - loudness
+	gainDb = math.Max(-maxNormalizationGainDb, math.Min(maxNormalizationGainDb, gainDb))
+	return math.Pow(10, gainDb/20)
+}
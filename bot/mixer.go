@@ -0,0 +1,387 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package bot
+
+import (
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// priorities of mixer sources. Sources are ducked while a source with higher
+// priority is playing, and held while an interrupting source is attached
+const (
+	PriorityMusic     = 0
+	PriorityEffect    = 1
+	PriorityInterrupt = 2
+)
+
+const (
+	// gain of ducked sources
+	duckGain = 0.3
+	// frames over which ducking is applied or released
+	duckFrames = 15
+	// time a mixing round waits for frames of started sources. Sources which
+	// have no frame by then are silent in that round
+	sourceFrameWait = 10 * time.Millisecond
+)
+
+// mixes PCM frames of all active sources of a guild and sends them to discord.
+// Used to crossfade between consecutive songs and to play sound effects over
+// music
+type Mixer struct {
+	mtx sync.Mutex
+
+	logCtx  string
+	voice   *discordgo.VoiceConnection
+	sources []*mixerSource
+	// settings of the opus encoder and their updates
+	encoderSettings EncoderSettings
+	encoderUpdates  chan EncoderSettings
+	// signal to wake up mixer when a source is added or resumed
+	wake    chan interface{}
+	stop    chan interface{}
+	stopped bool
+}
+
+// a PCM producer for the mixer
+type mixerSource struct {
+	// PCM frames of the source. Closed by the producer when it ends
+	frames chan []int16
+	// closed when the source is attached to the mixer
+	attached chan interface{}
+	// closed by the mixer when the source is removed
+	removed     chan interface{}
+	removedOnce sync.Once
+	// mixer the source is attached to
+	mixer *Mixer
+	// paused sources are skipped by the mixer without waiting for frames
+	paused atomic.Bool
+
+	priority int
+	gain     float64
+	// current fade gain and change of fade gain per frame while fading
+	fade     float64
+	fadeStep float64
+	// current gain from ducking
+	duck    float64
+	started bool
+	// number of frames to fade out other sources once this source starts
+	fadeFrames int
+}
+
+func NewMixer(logCtx string, voice *discordgo.VoiceConnection, encoderSettings EncoderSettings) *Mixer {
+	return &Mixer{
+		logCtx:          logCtx,
+		voice:           voice,
+		encoderSettings: encoderSettings,
+		encoderUpdates:  make(chan EncoderSettings, 1),
+		sources:         make([]*mixerSource, 0),
+		wake:            make(chan interface{}, 1),
+		stop:            make(chan interface{}),
+	}
+}
+
+// create a source which is not yet attached to the mixer. Producer can
+// buffer frames of the source till it is attached
+func newMixerSource(priority int, gain float64) *mixerSource {
+	return &mixerSource{
+		frames:   make(chan []int16, 2),
+		attached: make(chan interface{}),
+		removed:  make(chan interface{}),
+		priority: priority,
+		gain:     gain,
+		fade:     1,
+		duck:     1,
+	}
+}
+
+// notify producer that the source won't be mixed anymore
+func (source *mixerSource) remove() {
+	source.removedOnce.Do(func() {
+		close(source.removed)
+	})
+}
+
+// pause or resume the source. Mixer doesn't wait for frames of paused sources
+func (source *mixerSource) setPaused(paused bool) {
+	source.paused.Store(paused)
+	select {
+	case <-source.attached:
+		// mixer might be waiting for a frame of this source
+		source.mixer.wakeUp()
+	default:
+	}
+}
+
+// add a new source to the mixer
+func (mixer *Mixer) addSource(priority int, gain float64, fadeFrames int) *mixerSource {
+	source := newMixerSource(priority, gain)
+	mixer.attachSource(source, fadeFrames)
+	return source
+}
+
+// attach a source to the mixer. If fadeFrames is more than 0 the source fades
+// in over those frames while all other sources fade out
+func (mixer *Mixer) attachSource(source *mixerSource, fadeFrames int) {
+	mixer.mtx.Lock()
+	source.mixer = mixer
+	source.fadeFrames = fadeFrames
+	if fadeFrames > 0 {
+		source.fade = 0
+		source.fadeStep = 1 / float64(fadeFrames)
+	}
+	mixer.sources = append(mixer.sources, source)
+	close(source.attached)
+	mixer.mtx.Unlock()
+
+	mixer.wakeUp()
+}
+
+// wake up mixer if it is waiting for sources
+func (mixer *Mixer) wakeUp() {
+	select {
+	case mixer.wake <- nil:
+	default:
+	}
+}
+
+// remove a source from the mixer and notify its producer
+func (mixer *Mixer) removeSource(source *mixerSource) {
+	mixer.mtx.Lock()
+	defer mixer.mtx.Unlock()
+	for idx, src := range mixer.sources {
+		if src == source {
+			mixer.sources = append(mixer.sources[:idx], mixer.sources[idx+1:]...)
+			source.remove()
+			return
+		}
+	}
+}
+
+// remove all sources from the mixer
+func (mixer *Mixer) removeAllSources() {
+	mixer.mtx.Lock()
+	defer mixer.mtx.Unlock()
+	for _, source := range mixer.sources {
+		source.remove()
+	}
+	mixer.sources = make([]*mixerSource, 0)
+}
+
+// fade out all sources of the same priority except the given one
+func (mixer *Mixer) fadeOutOthers(source *mixerSource, fadeFrames int) {
+	mixer.mtx.Lock()
+	defer mixer.mtx.Unlock()
+	for _, src := range mixer.sources {
+		if src == source || src.priority != source.priority || src.fadeStep < 0 {
+			continue
+		}
+		src.fadeStep = -src.fade / float64(fadeFrames)
+	}
+}
+
+// a frame read from a source in a mixing round
+type sourceFrame struct {
+	source *mixerSource
+	frame  []int16
+}
+
+// mix frames of all sources and send them to discord till mixer is stopped
+func (mixer *Mixer) run() {
+	log.Printf("%s Starting mixer", mixer.logCtx)
+	out := make(chan []int16, 2)
+	go SendPCMPacket(mixer.logCtx, mixer.voice, mixer.encoderSettings, mixer.encoderUpdates, out)
+	defer close(out)
+
+	for {
+		mixer.mtx.Lock()
+		sources := make([]*mixerSource, 0, len(mixer.sources))
+		interrupted := false
+		for _, source := range mixer.sources {
+			if !source.paused.Load() {
+				sources = append(sources, source)
+				interrupted = interrupted || source.priority >= PriorityInterrupt
+			}
+		}
+		mixer.mtx.Unlock()
+		if interrupted {
+			sources = interruptingSources(sources)
+		}
+
+		if len(sources) == 0 {
+			select {
+			case <-mixer.wake:
+				continue
+			case <-mixer.stop:
+				log.Printf("%s Stopping mixer", mixer.logCtx)
+				return
+			}
+		}
+
+		hasStarted := false
+		for _, source := range sources {
+			hasStarted = hasStarted || source.started
+		}
+
+		// a stalled source must not hold other sources back, so sources are
+		// only waited for till the round times out
+		timeout := time.NewTimer(sourceFrameWait)
+		waiting := true
+		frames := make([]sourceFrame, 0, len(sources))
+		for _, source := range sources {
+			var frame []int16
+			var ok bool
+			// sources starting while others play are never waited for
+			if waiting && (source.started || !hasStarted) {
+				select {
+				case frame, ok = <-source.frames:
+				case <-timeout.C:
+					waiting = false
+					continue
+				case <-mixer.wake:
+					// a source was added or paused meanwhile, mix what is read
+					waiting = false
+					continue
+				case <-mixer.stop:
+					timeout.Stop()
+					log.Printf("%s Stopping mixer", mixer.logCtx)
+					return
+				}
+			} else {
+				select {
+				case frame, ok = <-source.frames:
+				default:
+					continue
+				}
+			}
+			if !ok {
+				mixer.removeSource(source)
+				continue
+			}
+			if !source.started {
+				source.started = true
+				if source.fadeFrames > 0 {
+					mixer.fadeOutOthers(source, source.fadeFrames)
+				}
+			}
+			frames = append(frames, sourceFrame{source: source, frame: frame})
+		}
+		timeout.Stop()
+		if len(frames) == 0 {
+			continue
+		}
+
+		// sources below the highest playing priority are ducked
+		maxPriority := PriorityMusic
+		for _, sf := range frames {
+			if sf.source.priority > maxPriority {
+				maxPriority = sf.source.priority
+			}
+		}
+		mixed := make([]int32, framesize*numChannels)
+		for _, sf := range frames {
+			if mixer.mixFrame(mixed, sf.frame, sf.source, sf.source.priority < maxPriority) {
+				mixer.removeSource(sf.source)
+			}
+		}
+
+		pcm := make([]int16, len(mixed))
+		for idx, sample := range mixed {
+			pcm[idx] = clipSample(float64(sample))
+		}
+		select {
+		case out <- pcm:
+		case <-mixer.stop:
+			log.Printf("%s Stopping mixer", mixer.logCtx)
+			return
+		}
+	}
+}
+
+// sources to mix while an interrupting source is attached. Frames of other
+// sources are not read, so their producers wait till the interruption ends
+func interruptingSources(sources []*mixerSource) []*mixerSource {
+	interrupting := make([]*mixerSource, 0, len(sources))
+	for _, source := range sources {
+		if source.priority >= PriorityInterrupt {
+			interrupting = append(interrupting, source)
+		}
+	}
+	return interrupting
+}
+
+// add frame of a source to mixed frame applying its gain, fade and ducking.
+// Returns true if the source has faded out completely
+func (mixer *Mixer) mixFrame(mixed []int32, frame []int16, source *mixerSource, ducked bool) bool {
+	mixer.mtx.Lock()
+	fadeStart := source.fade
+	fadeEnd := fadeStart + source.fadeStep
+	if fadeEnd >= 1 {
+		fadeEnd = 1
+		source.fadeStep = 0
+	}
+	if fadeEnd < 0 {
+		fadeEnd = 0
+	}
+	source.fade = fadeEnd
+
+	duckStart := source.duck
+	var duckEnd float64
+	duckStep := (1 - duckGain) / duckFrames
+	if ducked {
+		duckEnd = math.Max(duckGain, duckStart-duckStep)
+	} else {
+		duckEnd = math.Min(1, duckStart+duckStep)
+	}
+	source.duck = duckEnd
+	start := source.gain * fadeStart * duckStart
+	end := source.gain * fadeEnd * duckEnd
+	mixer.mtx.Unlock()
+
+	if start == 1 && end == 1 {
+		for idx := range frame {
+			mixed[idx] += int32(frame[idx])
+		}
+		return false
+	}
+	// ramp gain linearly over the samples
+	samplesPerChannel := len(frame) / numChannels
+	for idx := range frame {
+		pos := float64(idx/numChannels) / float64(samplesPerChannel)
+		gain := start + (end-start)*pos
+		mixed[idx] += int32(float64(frame[idx]) * gain)
+	}
+	return fadeEnd == 0 && fadeStart > fadeEnd
+}
+
+// apply new settings to the opus encoder. Only latest settings are kept if
+// encoder hasn't applied earlier ones yet
+func (mixer *Mixer) configureEncoder(settings EncoderSettings) {
+	mixer.mtx.Lock()
+	defer mixer.mtx.Unlock()
+	select {
+	case <-mixer.encoderUpdates:
+	default:
+	}
+	mixer.encoderUpdates <- settings
+}
+
+// stop the mixer
+func (mixer *Mixer) stopMixer() {
+	mixer.removeAllSources()
+	mixer.mtx.Lock()
+	defer mixer.mtx.Unlock()
+	if mixer.stopped {
+		return
+	}
+	mixer.stopped = true
+	close(mixer.stop)
+}
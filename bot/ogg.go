@@ -0,0 +1,137 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package bot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Ar5h71/r4-music-bot/musicmanager"
+)
+
+// extensions of local files which might be Ogg/Opus
+const (
+	opusExtension = ".opus"
+	oggExtension  = ".ogg"
+	ogaExtension  = ".oga"
+)
+
+var (
+	errOggNotOpus = errors.New("Ogg stream has no opus audio")
+	// discord paces opus packets at 20ms, so other packet durations can't be
+	// sent as they are
+	errOpusPacketDuration = errors.New("Opus packet is not 20ms long")
+)
+
+// samples of an opus frame at 48KHz by configuration in the TOC byte. SILK,
+// hybrid and CELT modes each have their own frame sizes
+var opusFrameSamples = [32]int{
+	480, 960, 1920, 2880, 480, 960, 1920, 2880, 480, 960, 1920, 2880,
+	480, 960, 480, 960,
+	120, 240, 480, 960, 120, 240, 480, 960, 120, 240, 480, 960, 120, 240, 480, 960,
+}
+
+// reads opus packets of an Ogg/Opus file
+type OggOpusReader struct {
+	ogg      *musicmanager.OggPacketReader
+	channels int
+	// samples at start of the stream which are not part of the audio
+	preSkip int64
+	// samples at 48KHz of packets read so far
+	samples int64
+}
+
+// read Ogg/Opus headers. Returns errOggNotOpus if the stream is not opus, like
+// Ogg/Vorbis files
+func NewOggOpusReader(reader io.Reader) (*OggOpusReader, error) {
+	ogg := musicmanager.NewOggPacketReader(bufio.NewReader(reader))
+	head, err := ogg.ReadPacket()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(head, []byte("OpusHead")) || len(head) < 19 {
+		return nil, errOggNotOpus
+	}
+	// comment header is not needed for playback
+	_, err = ogg.ReadPacket()
+	if err != nil {
+		return nil, err
+	}
+	return &OggOpusReader{
+		ogg:      ogg,
+		channels: int(head[9]),
+		preSkip:  int64(binary.LittleEndian.Uint16(head[10:12])),
+	}, nil
+}
+
+// number of audio channels of the stream
+func (oggOpus *OggOpusReader) Channels() int {
+	return oggOpus.channels
+}
+
+// read next opus packet with its timestamp in the stream. Returns
+// errOpusPacketDuration for packets which aren't 20ms long
+func (oggOpus *OggOpusReader) ReadPacket() ([]byte, time.Duration, error) {
+	for {
+		packet, err := oggOpus.ogg.ReadPacket()
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(packet) == 0 {
+			continue
+		}
+		if opusPacketSamples(packet) != framesize {
+			return nil, 0, errOpusPacketDuration
+		}
+		position := oggOpus.samples - oggOpus.preSkip
+		if position < 0 {
+			position = 0
+		}
+		oggOpus.samples += int64(framesize)
+		return packet, time.Duration(position) * time.Second / time.Duration(framerate), nil
+	}
+}
+
+// read next opus packet. Used to play Ogg/Opus files as sound effects
+func (oggOpus *OggOpusReader) ReadFrame() ([]byte, error) {
+	packet, _, err := oggOpus.ReadPacket()
+	return packet, err
+}
+
+// samples at 48KHz in an opus packet from its TOC byte
+func opusPacketSamples(packet []byte) int {
+	frameSamples := opusFrameSamples[packet[0]>>3]
+	switch packet[0] & 0x03 {
+	case 0:
+		return frameSamples
+	case 1, 2:
+		return 2 * frameSamples
+	default:
+		// frame count is in the next byte
+		if len(packet) < 2 {
+			return 0
+		}
+		return int(packet[1]&0x3F) * frameSamples
+	}
+}
+
+// check if url is a local file which might be Ogg/Opus
+func isOggFile(songUrl string) bool {
+	if !isLocalFile(songUrl) {
+		return false
+	}
+	switch strings.ToLower(filepath.Ext(songUrl)) {
+	case opusExtension, oggExtension, ogaExtension:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package bot
+
+// // libopus is linked by gopus. gopus doesn't expose FEC, packet loss and
+// // complexity controls, so the encoder is driven through opus_encoder_ctl here
+//
+// #include <stdint.h>
+//
+// typedef struct OpusEncoder OpusEncoder;
+//
+// extern int opus_encoder_get_size(int channels);
+// extern int opus_encoder_init(OpusEncoder *st, int32_t Fs, int channels, int application);
+// extern int32_t opus_encode(OpusEncoder *st, const int16_t *pcm, int frame_size, unsigned char *data, int32_t max_data_bytes);
+// extern int opus_encoder_ctl(OpusEncoder *st, int request, ...);
+//
+// enum {
+//   r4_application_audio = 2049,
+//   r4_set_bitrate = 4002,
+//   r4_set_vbr = 4006,
+//   r4_set_complexity = 4010,
+//   r4_set_inband_fec = 4012,
+//   r4_set_packet_loss_perc = 4014,
+// };
+//
+// static int r4_encoder_ctl(OpusEncoder *st, int request, int32_t value) {
+//   return opus_encoder_ctl(st, request, value);
+// }
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// opus encoder of the mixer with settings not exposed by gopus
+type opusEncoder struct {
+	// encoder state is allocated in go memory
+	data    []byte
+	encoder *C.OpusEncoder
+}
+
+func newOpusEncoder(sampleRate, channels int) (*opusEncoder, error) {
+	encoder := &opusEncoder{}
+	encoder.data = make([]byte, int(C.opus_encoder_get_size(C.int(channels))))
+	encoder.encoder = (*C.OpusEncoder)(unsafe.Pointer(&encoder.data[0]))
+	ret := C.opus_encoder_init(encoder.encoder, C.int32_t(sampleRate), C.int(channels), C.r4_application_audio)
+	if ret != 0 {
+		return nil, fmt.Errorf("Failed to initialize opus encoder. Error code: %d", int(ret))
+	}
+	return encoder, nil
+}
+
+// apply encoder settings. Settings take effect from the next encoded frame
+func (encoder *opusEncoder) configure(settings EncoderSettings) error {
+	vbr := 0
+	if settings.Vbr {
+		vbr = 1
+	}
+	fec := 0
+	if settings.Fec {
+		fec = 1
+	}
+	controls := []struct {
+		name    string
+		request C.int
+		value   int
+	}{
+		{"bitrate", C.r4_set_bitrate, settings.BitrateKbps * 1000},
+		{"vbr", C.r4_set_vbr, vbr},
+		{"complexity", C.r4_set_complexity, settings.Complexity},
+		{"inband fec", C.r4_set_inband_fec, fec},
+		{"packet loss", C.r4_set_packet_loss_perc, settings.PacketLossPercent},
+	}
+	for _, control := range controls {
+		ret := C.r4_encoder_ctl(encoder.encoder, control.request, C.int32_t(control.value))
+		if ret != 0 {
+			return fmt.Errorf("Failed to set opus encoder %s to %d. Error code: %d", control.name, control.value, int(ret))
+		}
+	}
+	return nil
+}
+
+// encode an interleaved PCM frame to an opus packet
+func (encoder *opusEncoder) encode(pcm []int16, frameSize, maxDataBytes int) ([]byte, error) {
+	data := make([]byte, maxDataBytes)
+	ret := C.opus_encode(encoder.encoder, (*C.int16_t)(unsafe.Pointer(&pcm[0])), C.int(frameSize),
+		(*C.uchar)(unsafe.Pointer(&data[0])), C.int32_t(len(data)))
+	if ret < 0 {
+		return nil, fmt.Errorf("Failed to encode opus frame. Error code: %d", int(ret))
+	}
+	return data[:ret], nil
+}
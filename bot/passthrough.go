@@ -0,0 +1,180 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package bot
+
+import (
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Ar5h71/r4-music-bot/common"
+	"github.com/Ar5h71/r4-music-bot/musicmanager"
+)
+
+// check if song stream is WebM with opus audio which discord can play as is
+func isOpusStream(stream *common.StreamInfo) bool {
+	return strings.HasPrefix(stream.MimeType, "audio/webm") &&
+		strings.Contains(stream.MimeType, "opus") &&
+		stream.SampleRate == framerate
+}
+
+// demuxes opus packets with their timestamps in the song
+type opusPacketReader interface {
+	ReadPacket() ([]byte, time.Duration, error)
+}
+
+// check if opus packets of the song can be sent without decoding. Must be
+// called with mutex held
+func (audioStream *AudioStreamSession) canPassthrough() bool {
+	stream := audioStream.streamInfo
+	return !audioStream.forcePCM && stream != nil && (isOpusStream(stream) || isOggFile(stream.Url)) &&
+		audioStream.settings.passthroughAllowed() && audioStream.canPassthroughFrom(stream)
+}
+
+// passthrough reads the song from its start and skips packets till the current
+// position. Remote streams which don't start at the beginning, like after a
+// seek, are played with the transcoder instead as it can seek in the stream.
+// Must be called with mutex held
+func (audioStream *AudioStreamSession) canPassthroughFrom(stream *common.StreamInfo) bool {
+	return audioStream.framesSent == 0 || isLocalFile(stream.Url)
+}
+
+// check if error means that the song has to be decoded as it can't be passed
+// through
+func isPassthroughUnsupported(err error) bool {
+	return err == errWebMNotOpus || err == errOggNotOpus || err == errOpusPacketDuration
+}
+
+// open stream of the song for passthrough. Local files, like Ogg files and
+// cached WebM streams, are read directly, other songs are streamed from their
+// url
+func (audioStream *AudioStreamSession) openPassthroughSource() (io.ReadCloser, error) {
+	streamUrl := audioStream.streamInfo.Url
+	if isLocalFile(streamUrl) {
+		return os.Open(strings.TrimPrefix(streamUrl, "file://"))
+	}
+	return musicmanager.OpenStream(streamUrl), nil
+}
+
+// demux opus packets from WebM stream or Ogg/Opus file of the song and send
+// them directly to discord from current frame position. Returns an error for
+// which isPassthroughUnsupported is true if the song can't be passed through
+func (audioStream *AudioStreamSession) passthroughFromCurrentFrame(logCtx string) error {
+	audioStream.mtx.Lock()
+	if audioStream.stopped {
+		audioStream.mtx.Unlock()
+		return nil
+	}
+	offset := framesToDuration(audioStream.framesSent)
+	stream, err := audioStream.openPassthroughSource()
+	if err != nil {
+		audioStream.mtx.Unlock()
+		log.Printf("%s Failed to open stream for passthrough. Got error: %s", logCtx, err.Error())
+		return err
+	}
+	audioStream.closeSource = func() {
+		stream.Close()
+	}
+	audioStream.passthrough = true
+	audioStream.speed = 1
+	audioStream.frameCarry = 0
+	audioStream.mtx.Unlock()
+	defer stream.Close()
+
+	log.Printf("%s Streaming opus packets without decoding from %s", logCtx, offset.String())
+	var packets opusPacketReader
+	if isOggFile(audioStream.streamInfo.Url) {
+		packets, err = NewOggOpusReader(stream)
+	} else {
+		packets, err = NewWebMReader(stream)
+	}
+	if err != nil {
+		if audioStream.interrupted() {
+			return nil
+		}
+		if !isPassthroughUnsupported(err) {
+			log.Printf("%s Failed to read opus stream. Got error: %s", logCtx, err.Error())
+		}
+		return err
+	}
+
+	for {
+		if audioStream.waitWhilePaused() {
+			return nil
+		}
+		packet, timestamp, err := packets.ReadPacket()
+		if err != nil && audioStream.interrupted() {
+			return nil
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return err
+		}
+		if err != nil {
+			if !isPassthroughUnsupported(err) {
+				log.Printf("%s Failed to read opus packet: error: [%s]", logCtx, err.Error())
+			}
+			return err
+		}
+		// skip packets before the position to start from
+		if timestamp < offset {
+			continue
+		}
+
+		audioStream.mtx.Lock()
+		if audioStream.seeking || audioStream.stopped {
+			audioStream.mtx.Unlock()
+			return nil
+		}
+		audioStream.framesSent = durationToFrames(timestamp + opusPacketDuration)
+		audioStream.checkNearEnd()
+		position := audioStream.framesSent
+		audioStream.mtx.Unlock()
+
+		if !audioStream.sendOpus(packet, position) {
+			// removed from mixer
+			return nil
+		}
+	}
+}
+
+// send an opus packet to discord once the source is attached to the mixer.
+// Packets are buffered while prefetching. Returns false if the source was
+// removed
+func (audioStream *AudioStreamSession) sendOpus(packet []byte, position int) bool {
+	select {
+	case <-audioStream.source.attached:
+	default:
+		if len(audioStream.prebuffer) < prefetchFrames {
+			audioStream.prebuffer = append(audioStream.prebuffer, bufferedFrame{opus: packet, position: position})
+			return true
+		}
+	}
+	if !audioStream.flushPrebuffer() {
+		return false
+	}
+	return audioStream.sendOpusPacket(packet, position)
+}
+
+// send an opus packet to the voice connection. Returns false if the source
+// was removed
+func (audioStream *AudioStreamSession) sendOpusPacket(packet []byte, position int) bool {
+	voice := audioStream.voice
+	if voice.Ready == false || voice.OpusSend == nil {
+		// drop the packet but keep the pace
+		time.Sleep(opusPacketDuration)
+		audioStream.setPlayed(position)
+		return true
+	}
+	select {
+	case voice.OpusSend <- packet:
+		audioStream.setPlayed(position)
+		return true
+	case <-audioStream.source.removed:
+		return false
+	}
+}
@@ -0,0 +1,185 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package bot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	wavExtension = ".wav"
+	// raw files are 48KHz stereo s16le PCM
+	pcmExtension = ".pcm"
+	rawExtension = ".raw"
+
+	wavFormatPCM        = 1
+	wavFormatExtensible = 0xFFFE
+	// max size of a WAV format chunk read into memory
+	wavMaxFormatSize = 1024
+)
+
+// decodes local WAV and raw PCM files without ffmpeg. WAV files need to be 16
+// bit PCM at 48KHz with one or two channels
+type PCMFileTranscoder struct{}
+
+func (PCMFileTranscoder) Open(songUrl string, offset time.Duration, filter string) (io.ReadCloser, error) {
+	if filter != "" {
+		return nil, errFiltersNotSupported
+	}
+	path := strings.TrimPrefix(songUrl, "file://")
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	channels := numChannels
+	dataSize := int64(-1)
+	if strings.ToLower(filepath.Ext(path)) == wavExtension {
+		channels, dataSize, err = readWavHeader(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	// seek to the frame at offset
+	skip := int64(durationToFrames(offset)*framesize*channels) * 2
+	if dataSize >= 0 && skip > dataSize {
+		skip = dataSize
+	}
+	_, err = file.Seek(skip, io.SeekCurrent)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	var reader io.Reader = file
+	if dataSize >= 0 {
+		// chunks after audio data are not played
+		reader = io.LimitReader(file, dataSize-skip)
+	}
+	if channels == 1 {
+		reader = &monoToStereoReader{reader: reader}
+	}
+	return &pcmFile{
+		Reader: reader,
+		file:   file,
+	}, nil
+}
+
+// read header of a WAV file till start of its audio data. Returns number of
+// channels and size of audio data
+func readWavHeader(file io.Reader) (int, int64, error) {
+	var riff struct {
+		Id     [4]byte
+		Size   uint32
+		Format [4]byte
+	}
+	err := binary.Read(file, binary.LittleEndian, &riff)
+	if err != nil || string(riff.Id[:]) != "RIFF" || string(riff.Format[:]) != "WAVE" {
+		return 0, 0, fmt.Errorf("Not a WAV file")
+	}
+
+	channels := 0
+	for {
+		var chunk struct {
+			Id   [4]byte
+			Size uint32
+		}
+		err = binary.Read(file, binary.LittleEndian, &chunk)
+		if err != nil {
+			return 0, 0, fmt.Errorf("WAV file has no audio data")
+		}
+		switch string(chunk.Id[:]) {
+		case "fmt ":
+			var format struct {
+				AudioFormat   uint16
+				Channels      uint16
+				SampleRate    uint32
+				ByteRate      uint32
+				BlockAlign    uint16
+				BitsPerSample uint16
+			}
+			if chunk.Size > wavMaxFormatSize {
+				return 0, 0, fmt.Errorf("Invalid WAV format chunk")
+			}
+			// chunks are padded to even size
+			data := make([]byte, chunk.Size+chunk.Size%2)
+			_, err = io.ReadFull(file, data)
+			if err == nil {
+				err = binary.Read(bytes.NewReader(data), binary.LittleEndian, &format)
+			}
+			if err != nil {
+				return 0, 0, fmt.Errorf("Invalid WAV format chunk")
+			}
+			if format.AudioFormat != wavFormatPCM && format.AudioFormat != wavFormatExtensible {
+				return 0, 0, fmt.Errorf("Unsupported WAV audio format %d", format.AudioFormat)
+			}
+			if format.BitsPerSample != 16 || int(format.SampleRate) != framerate ||
+				format.Channels < 1 || format.Channels > 2 {
+				return 0, 0, fmt.Errorf("WAV file needs to be 16 bit mono or stereo at %dHz", framerate)
+			}
+			channels = int(format.Channels)
+		case "data":
+			if channels == 0 {
+				return 0, 0, fmt.Errorf("WAV file has no format chunk")
+			}
+			return channels, int64(chunk.Size), nil
+		default:
+			// chunks are padded to even size
+			_, err = io.CopyN(io.Discard, file, int64(chunk.Size+chunk.Size%2))
+			if err != nil {
+				return 0, 0, fmt.Errorf("WAV file has no audio data")
+			}
+		}
+	}
+}
+
+// PCM of a local file
+type pcmFile struct {
+	io.Reader
+	file *os.File
+}
+
+func (pcm *pcmFile) Close() error {
+	return pcm.file.Close()
+}
+
+// duplicates samples of mono s16le PCM to both stereo channels
+type monoToStereoReader struct {
+	reader io.Reader
+	buf    []byte
+}
+
+func (mono *monoToStereoReader) Read(buf []byte) (int, error) {
+	// each mono sample of 2 bytes becomes 4 bytes
+	size := len(buf) / 4 * 2
+	if size == 0 {
+		return 0, io.ErrShortBuffer
+	}
+	if len(mono.buf) < size {
+		mono.buf = make([]byte, size)
+	}
+	n, err := io.ReadFull(mono.reader, mono.buf[:size])
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	samples := n / 2
+	for idx := 0; idx < samples; idx++ {
+		copy(buf[4*idx:], mono.buf[2*idx:2*idx+2])
+		copy(buf[4*idx+2:], mono.buf[2*idx:2*idx+2])
+	}
+	if samples == 0 && err == nil {
+		err = io.EOF
+	}
+	return samples * 4, err
+}
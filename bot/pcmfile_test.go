@@ -0,0 +1,255 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package bot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// a chunk of a WAV file
+type wavChunk struct {
+	id   string
+	data []byte
+}
+
+// build a WAV file from chunks, padding chunks of odd size
+func buildWav(chunks ...wavChunk) []byte {
+	body := bytes.NewBufferString("WAVE")
+	for _, chunk := range chunks {
+		body.WriteString(chunk.id)
+		binary.Write(body, binary.LittleEndian, uint32(len(chunk.data)))
+		body.Write(chunk.data)
+		if len(chunk.data)%2 == 1 {
+			body.WriteByte(0)
+		}
+	}
+	wav := bytes.NewBufferString("RIFF")
+	binary.Write(wav, binary.LittleEndian, uint32(body.Len()))
+	wav.Write(body.Bytes())
+	return wav.Bytes()
+}
+
+// format chunk of 16 bit PCM. Extra bytes are appended to the chunk
+func wavFormat(channels, sampleRate int, extra ...byte) wavChunk {
+	data := new(bytes.Buffer)
+	binary.Write(data, binary.LittleEndian, struct {
+		AudioFormat   uint16
+		Channels      uint16
+		SampleRate    uint32
+		ByteRate      uint32
+		BlockAlign    uint16
+		BitsPerSample uint16
+	}{
+		AudioFormat:   wavFormatPCM,
+		Channels:      uint16(channels),
+		SampleRate:    uint32(sampleRate),
+		ByteRate:      uint32(sampleRate * channels * 2),
+		BlockAlign:    uint16(channels * 2),
+		BitsPerSample: 16,
+	})
+	data.Write(extra)
+	return wavChunk{id: "fmt ", data: data.Bytes()}
+}
+
+// s16le samples counting up from start
+func pcmSamples(start, count int) []byte {
+	data := make([]byte, 2*count)
+	for idx := 0; idx < count; idx++ {
+		binary.LittleEndian.PutUint16(data[2*idx:], uint16(start+idx))
+	}
+	return data
+}
+
+func TestReadWavHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		wav      []byte
+		channels int
+		dataSize int64
+		wantErr  bool
+	}{
+		{
+			name:     "stereo",
+			wav:      buildWav(wavFormat(2, framerate), wavChunk{id: "data", data: pcmSamples(0, 8)}),
+			channels: 2,
+			dataSize: 16,
+		},
+		{
+			name:     "mono",
+			wav:      buildWav(wavFormat(1, framerate), wavChunk{id: "data", data: pcmSamples(0, 8)}),
+			channels: 1,
+			dataSize: 16,
+		},
+		{
+			name: "odd sized chunks before data",
+			wav: buildWav(
+				wavChunk{id: "LIST", data: []byte("abc")},
+				wavFormat(2, framerate, 0x01),
+				wavChunk{id: "junk", data: []byte("x")},
+				wavChunk{id: "data", data: pcmSamples(0, 4)},
+			),
+			channels: 2,
+			dataSize: 8,
+		},
+		{
+			name:    "wrong sample rate",
+			wav:     buildWav(wavFormat(2, 44100), wavChunk{id: "data", data: pcmSamples(0, 4)}),
+			wantErr: true,
+		},
+		{
+			name:    "no format chunk",
+			wav:     buildWav(wavChunk{id: "data", data: pcmSamples(0, 4)}),
+			wantErr: true,
+		},
+		{
+			name:    "no data chunk",
+			wav:     buildWav(wavFormat(2, framerate)),
+			wantErr: true,
+		},
+		{
+			name:    "not a wav file",
+			wav:     []byte("OggS not a wav file at all"),
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := bytes.NewReader(test.wav)
+			channels, dataSize, err := readWavHeader(reader)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("readWavHeader() didn't fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readWavHeader() failed: %s", err.Error())
+			}
+			if channels != test.channels || dataSize != test.dataSize {
+				t.Fatalf("readWavHeader() = %d channels, %d bytes. Want %d channels, %d bytes",
+					channels, dataSize, test.channels, test.dataSize)
+			}
+			// header is read till start of audio data
+			data := make([]byte, 2)
+			io.ReadFull(reader, data)
+			if !bytes.Equal(data, pcmSamples(0, 1)) {
+				t.Fatalf("reader is not at start of audio data")
+			}
+		})
+	}
+}
+
+func TestMonoToStereoReader(t *testing.T) {
+	mono := &monoToStereoReader{reader: bytes.NewReader(pcmSamples(1, 5))}
+	// buffer for 2 stereo samples, so reads end in the middle of the data
+	stereo := make([]byte, 0)
+	buf := make([]byte, 8)
+	for {
+		n, err := mono.Read(buf)
+		stereo = append(stereo, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read() failed: %s", err.Error())
+		}
+	}
+
+	want := make([]byte, 0)
+	for sample := 1; sample <= 5; sample++ {
+		want = append(want, pcmSamples(sample, 1)...)
+		want = append(want, pcmSamples(sample, 1)...)
+	}
+	if !bytes.Equal(stereo, want) {
+		t.Fatalf("Read() = %v, want %v", stereo, want)
+	}
+
+	_, err := mono.Read(make([]byte, 3))
+	if err != io.ErrShortBuffer {
+		t.Fatalf("Read() with buffer smaller than a stereo sample returned %v", err)
+	}
+}
+
+func TestPCMFileTranscoder(t *testing.T) {
+	dir := t.TempDir()
+	samplesPerFrame := framesize * numChannels
+	// two frames of audio followed by a chunk which isn't played
+	wavPath := filepath.Join(dir, "song.wav")
+	wav := buildWav(
+		wavFormat(2, framerate),
+		wavChunk{id: "data", data: pcmSamples(0, 2*samplesPerFrame)},
+		wavChunk{id: "LIST", data: []byte("trailing tags")},
+	)
+	monoPath := filepath.Join(dir, "mono.wav")
+	mono := buildWav(wavFormat(1, framerate), wavChunk{id: "data", data: pcmSamples(0, 2*framesize)})
+	rawPath := filepath.Join(dir, "song.raw")
+	raw := pcmSamples(0, 2*samplesPerFrame)
+	for path, data := range map[string][]byte{wavPath: wav, monoPath: mono, rawPath: raw} {
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// stereo PCM of second frame of each file
+	secondFrame := pcmSamples(samplesPerFrame, samplesPerFrame)
+	monoSecondFrame := make([]byte, 0)
+	for sample := framesize; sample < 2*framesize; sample++ {
+		monoSecondFrame = append(monoSecondFrame, pcmSamples(sample, 1)...)
+		monoSecondFrame = append(monoSecondFrame, pcmSamples(sample, 1)...)
+	}
+	tests := []struct {
+		name string
+		url  string
+		want []byte
+	}{
+		{name: "wav", url: wavPath, want: secondFrame},
+		{name: "wav file url", url: "file://" + wavPath, want: secondFrame},
+		{name: "mono wav", url: monoPath, want: monoSecondFrame},
+		{name: "raw", url: rawPath, want: secondFrame},
+	}
+	offset := framesToDuration(1)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pcm, err := PCMFileTranscoder{}.Open(test.url, offset, "")
+			if err != nil {
+				t.Fatalf("Open() failed: %s", err.Error())
+			}
+			defer pcm.Close()
+			data, err := io.ReadAll(pcm)
+			if err != nil {
+				t.Fatalf("reading PCM failed: %s", err.Error())
+			}
+			if !bytes.Equal(data, test.want) {
+				t.Fatalf("read %d bytes of PCM from second frame, want %d bytes", len(data), len(test.want))
+			}
+		})
+	}
+
+	t.Run("offset past end", func(t *testing.T) {
+		pcm, err := PCMFileTranscoder{}.Open(wavPath, time.Minute, "")
+		if err != nil {
+			t.Fatalf("Open() failed: %s", err.Error())
+		}
+		defer pcm.Close()
+		data, _ := io.ReadAll(pcm)
+		if len(data) != 0 {
+			t.Fatalf("read %d bytes past end of audio data", len(data))
+		}
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		_, err := PCMFileTranscoder{}.Open(wavPath, 0, "volume=2")
+		if err != errFiltersNotSupported {
+			t.Fatalf("Open() with filter returned %v, want errFiltersNotSupported", err)
+		}
+	})
+}
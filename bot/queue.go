@@ -6,11 +6,14 @@ E-mail: ad.sigh.arsh@gmail.com
 package bot
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"time"
 
 	"github.com/Ar5h71/r4-music-bot/common"
+	"github.com/Ar5h71/r4-music-bot/musicmanager"
 )
 
 // function to play the queue
@@ -22,23 +25,31 @@ func QueueInit(recv <-chan *SongSignal) {
 			log.Printf("[%s(%s)] Adding to queue for bot in guild (%s) and vchannel (%s)",
 				songSigRecv.song.SongTitle, songSigRecv.song.SongId, songSigRecv.botInstance.GuildId,
 				songSigRecv.botInstance.VoiceChannelId)
-			go songSigRecv.botInstance.playQueue(songSigRecv.song, songSigRecv.playNow)
+			go songSigRecv.botInstance.playQueue(songSigRecv.song, songSigRecv.more, songSigRecv.playNow)
 		}
 	}
 }
 
-func (botInstance *BotInstance) playQueue(song *common.Song, playnow bool) {
+func (botInstance *BotInstance) playQueue(song *common.Song, more []*common.Song, playnow bool) {
+	songs := append([]*common.Song{song}, more...)
 	if playnow {
-		// add the song to queue front
-		botInstance.addSongFront(song)
+		// add the songs to queue front
+		botInstance.addSongFront(songs...)
 	} else {
-		botInstance.addSongBack(song)
+		botInstance.addSongBack(songs...)
 	}
-	if botInstance.Queue.nowPlaying != nil {
+	botInstance.Queue.mtx.Lock()
+	if botInstance.Queue.running {
+		botInstance.Queue.mtx.Unlock()
 		log.Printf("[%s | %s]Bot is already playing",
 			botInstance.GuildId, botInstance.VoiceChannelId)
 		return
 	}
+	botInstance.Queue.running = true
+	botInstance.Queue.mtx.Unlock()
+
+	// start playing without waiting for the ticker
+	botInstance.signalNext()
 	go func() {
 		// spawn a go routine to check if queue is empty
 		ticker := time.NewTicker(1 * time.Second)
@@ -56,10 +67,22 @@ func (botInstance *BotInstance) playQueue(song *common.Song, playnow bool) {
 			case <-botInstance.Queue.resume:
 				// resume current song
 				botInstance.resumeSong()
+			case position := <-botInstance.Queue.seek:
+				// seek current song
+				botInstance.seekSong(position)
+			case streamSession := <-botInstance.Queue.prefetch:
+				// current song is about to end, start decoding next song
+				botInstance.prefetchNext(streamSession)
+			case streamSession := <-botInstance.Queue.nearEnd:
+				// current song is about to end, crossfade into next song
+				botInstance.crossfadeNext(streamSession)
 			case <-botInstance.Queue.done:
 				// queue is finished return
 				StopBotInstance(botInstance)
 				return
+			case <-botInstance.Queue.next:
+				// current song finished or skipped
+				botInstance.playNext()
 			case <-ticker.C:
 				// check if anything is playing
 				// if not start playing
@@ -78,19 +101,23 @@ func (botInstance *BotInstance) playQueue(song *common.Song, playnow bool) {
 
 }
 
-// Add song to queue back
-func (botInstance *BotInstance) addSongBack(song *common.Song) {
-	log.Printf("[%s(%s)] Adding to queue back", song.SongTitle, song.SongId)
+// Add songs to queue back in order
+func (botInstance *BotInstance) addSongBack(songs ...*common.Song) {
+	for _, song := range songs {
+		log.Printf("[%s(%s)] Adding to queue back", song.SongTitle, song.SongId)
+	}
 	botInstance.Queue.mtx.Lock()
-	botInstance.Queue.songs = append(botInstance.Queue.songs, song)
+	botInstance.Queue.songs = append(botInstance.Queue.songs, songs...)
 	botInstance.Queue.mtx.Unlock()
 }
 
-// Add song to queue front
-func (botInstance *BotInstance) addSongFront(song *common.Song) {
-	log.Printf("[%s(%s)] Adding to queue front", song.SongTitle, song.SongId)
+// Add songs to queue front in order
+func (botInstance *BotInstance) addSongFront(songs ...*common.Song) {
+	for _, song := range songs {
+		log.Printf("[%s(%s)] Adding to queue front", song.SongTitle, song.SongId)
+	}
 	botInstance.Queue.mtx.Lock()
-	botInstance.Queue.songs = append([]*common.Song{song}, botInstance.Queue.songs...)
+	botInstance.Queue.songs = append(append([]*common.Song{}, songs...), botInstance.Queue.songs...)
 	botInstance.Queue.mtx.Unlock()
 }
 
@@ -106,9 +133,10 @@ func (botInstance *BotInstance) skipSong() {
 		sendMessageToChannel(botInstance, common.Boldify("No song is playing. Nothing to skip"))
 		return
 	}
-	botInstance.Queue.nowPlaying.streamSession.stop <- nil
+	botInstance.Queue.nowPlaying.streamSession.stopStream()
 	// make nowPlaying nil
 	botInstance.Queue.nowPlaying = nil
+	botInstance.signalNext()
 }
 
 // delete all songs from queue
@@ -128,10 +156,13 @@ func (botInstance *BotInstance) stopQueue() {
 	if botInstance.Queue.nowPlaying != nil {
 		log.Printf("[%s | %s] Stopping current song",
 			botInstance.GuildId, botInstance.TextChannelId)
-		botInstance.Queue.nowPlaying.streamSession.stop <- nil
+		botInstance.Queue.nowPlaying.streamSession.stopStream()
 		botInstance.Queue.nowPlaying = nil
 		nothingToStop = false
 	}
+	// stop any song which is still fading out or prefetched
+	botInstance.Mixer.removeAllSources()
+	botInstance.discardPrefetched()
 	if nothingToStop {
 		log.Printf("[%s | %s] Nothing to stop",
 			botInstance.GuildId, botInstance.VoiceChannelId)
@@ -181,8 +212,153 @@ func (botInstance *BotInstance) resumeSong() {
 		sendMessageToChannel(botInstance, common.Boldify("Queue is already playing. Nothing to resume"))
 		return
 	}
-	botInstance.Queue.nowPlaying.streamSession.resumeStream()
 	botInstance.Queue.paused = false
+	botInstance.Queue.nowPlaying.streamSession.resumeStream()
+}
+
+// seek current song to given position
+func (botInstance *BotInstance) seekSong(position time.Duration) {
+	log.Printf("[%s | %s] Seeking current song to %s",
+		botInstance.GuildId, botInstance.VoiceChannelId, position.String())
+	botInstance.Queue.mtx.Lock()
+	defer botInstance.Queue.mtx.Unlock()
+	if botInstance.Queue.nowPlaying == nil {
+		log.Printf("[%s | %s] Nothing to seek",
+			botInstance.GuildId, botInstance.VoiceChannelId)
+		sendMessageToChannel(botInstance, common.Boldify("No song is playing. Nothing to seek"))
+		return
+	}
+	botInstance.Queue.nowPlaying.streamSession.seekStream(position)
+}
+
+// current song, its elapsed time, playback speed and whether it is paused
+func (botInstance *BotInstance) nowPlayingStatus() (*common.Song, time.Duration, float64, bool, error) {
+	botInstance.Queue.mtx.Lock()
+	defer botInstance.Queue.mtx.Unlock()
+	if botInstance.Queue.nowPlaying == nil {
+		return nil, 0, 0, false, fmt.Errorf("No song is playing")
+	}
+	nowPlaying := botInstance.Queue.nowPlaying
+	return nowPlaying.song, nowPlaying.streamSession.elapsed(), nowPlaying.streamSession.playbackSpeed(),
+		botInstance.Queue.paused, nil
+}
+
+// set volume for the guild. Volume is applied to current and next songs
+func (botInstance *BotInstance) setVolume(volume int) {
+	log.Printf("[%s | %s] Setting volume to %d",
+		botInstance.GuildId, botInstance.VoiceChannelId, volume)
+	botInstance.AudioSettings.setVolume(volume)
+	botInstance.applySettingsToCurrentSong()
+}
+
+// set crossfade duration between consecutive songs for the guild
+func (botInstance *BotInstance) setCrossfade(crossfade time.Duration) {
+	log.Printf("[%s | %s] Setting crossfade to %s",
+		botInstance.GuildId, botInstance.VoiceChannelId, crossfade.String())
+	botInstance.AudioSettings.setCrossfade(crossfade)
+	botInstance.applySettingsToCurrentSong()
+}
+
+// set loudness normalization for the guild. Loudness of each song is brought
+// close to the target loudness in LUFS
+func (botInstance *BotInstance) setNormalization(normalize bool, targetLoudness int) {
+	log.Printf("[%s | %s] Setting loudness normalization to %t with target %d LUFS",
+		botInstance.GuildId, botInstance.VoiceChannelId, normalize, targetLoudness)
+	botInstance.AudioSettings.setNormalization(normalize, targetLoudness)
+	botInstance.applySettingsToCurrentSong()
+}
+
+// set opus encoder quality for the guild. Bitrate of 0 matches bitrate of the
+// voice channel. Returns settings applied to the encoder
+func (botInstance *BotInstance) setAudioQuality(bitrateKbps int, fec bool, packetLossPercent int) EncoderSettings {
+	botInstance.AudioSettings.setEncoderQuality(bitrateKbps, fec, packetLossPercent)
+	settings := botInstance.AudioSettings.encoderSettings(voiceChannelBitrateKbps(botInstance.BotSession, botInstance.VoiceChannelId))
+	log.Printf("[%s | %s] Setting audio quality to %+v",
+		botInstance.GuildId, botInstance.VoiceChannelId, settings)
+	botInstance.Mixer.configureEncoder(settings)
+	return settings
+}
+
+// set equalizer of the guild to a saved curve, or set gain of a single band
+// if band frequency is given. Returns equalizer gains and curve name
+func (botInstance *BotInstance) setEqualizer(curve string, bandFrequency int, gainDb float64) ([eqBands]float64, string) {
+	if curve != "" {
+		log.Printf("[%s | %s] Setting equalizer curve to '%s'",
+			botInstance.GuildId, botInstance.VoiceChannelId, curve)
+		botInstance.AudioSettings.setEqCurve(curve)
+	}
+	if band, ok := eqBandIndex(bandFrequency); ok {
+		log.Printf("[%s | %s] Setting equalizer band %dHz to %.0fdB",
+			botInstance.GuildId, botInstance.VoiceChannelId, bandFrequency, gainDb)
+		botInstance.AudioSettings.setEqBand(band, gainDb)
+	}
+	// equalizer is applied live on PCM, only passthrough needs a restart
+	botInstance.applySettingsToCurrentSong()
+	return botInstance.AudioSettings.getEqualizer()
+}
+
+// switch current song from opus passthrough to PCM if changed settings need
+// the song to be decoded
+func (botInstance *BotInstance) applySettingsToCurrentSong() {
+	botInstance.Queue.mtx.Lock()
+	defer botInstance.Queue.mtx.Unlock()
+	if botInstance.Queue.nowPlaying == nil {
+		return
+	}
+	botInstance.Queue.nowPlaying.streamSession.applySettings()
+}
+
+// toggle a filter preset for the guild and apply it to current song. Returns
+// names of active filters
+func (botInstance *BotInstance) toggleFilter(name string) []string {
+	if name == FilterOff {
+		log.Printf("[%s | %s] Removing all filters",
+			botInstance.GuildId, botInstance.VoiceChannelId)
+		botInstance.AudioSettings.clearFilters()
+	} else {
+		active := botInstance.AudioSettings.toggleFilter(name)
+		log.Printf("[%s | %s] Filter '%s' active: %t",
+			botInstance.GuildId, botInstance.VoiceChannelId, name, active)
+	}
+	botInstance.restartCurrentSong()
+	return botInstance.AudioSettings.activeFilters()
+}
+
+// set playback speed for the guild. Pitch is kept and current song restarts
+// at its position
+func (botInstance *BotInstance) setSpeed(speed float64) {
+	log.Printf("[%s | %s] Setting playback speed to %.2f",
+		botInstance.GuildId, botInstance.VoiceChannelId, speed)
+	botInstance.AudioSettings.setSpeed(speed)
+	botInstance.restartCurrentSong()
+}
+
+// set pitch shift in semitones for the guild. Speed is kept and current song
+// restarts at its position
+func (botInstance *BotInstance) setPitch(pitch int) {
+	log.Printf("[%s | %s] Setting pitch shift to %d semitones",
+		botInstance.GuildId, botInstance.VoiceChannelId, pitch)
+	botInstance.AudioSettings.setPitch(pitch)
+	botInstance.restartCurrentSong()
+}
+
+// set silence trimming for the guild. Silence at start and end of songs is
+// skipped so the queue advances as soon as the music ends
+func (botInstance *BotInstance) setTrimSilence(trimSilence bool) {
+	log.Printf("[%s | %s] Setting silence trimming to %t",
+		botInstance.GuildId, botInstance.VoiceChannelId, trimSilence)
+	botInstance.AudioSettings.setTrimSilence(trimSilence)
+	botInstance.applySettingsToCurrentSong()
+}
+
+// restart current song at its position to apply changed audio settings
+func (botInstance *BotInstance) restartCurrentSong() {
+	botInstance.Queue.mtx.Lock()
+	defer botInstance.Queue.mtx.Unlock()
+	if botInstance.Queue.nowPlaying == nil {
+		return
+	}
+	botInstance.Queue.nowPlaying.streamSession.restartStream()
 }
 
 // play next song in queue
@@ -195,10 +371,78 @@ func (botInstance *BotInstance) playNext() {
 	}()
 	botInstance.Queue.mtx.Lock()
 	defer botInstance.Queue.mtx.Unlock()
-	if len(botInstance.Queue.songs) == 0 && botInstance.Queue.nowPlaying == nil {
+	if botInstance.Queue.nowPlaying != nil {
+		return
+	}
+	if len(botInstance.Queue.songs) == 0 {
 		botInstance.Queue.done <- nil
 		return
 	}
+	botInstance.startNextSong(0)
+}
+
+// signal queue to start next song without waiting for the ticker
+func (botInstance *BotInstance) signalNext() {
+	select {
+	case botInstance.Queue.next <- nil:
+	default:
+	}
+}
+
+// start decoding next song in queue so it can start without a gap when current
+// song ends
+func (botInstance *BotInstance) prefetchNext(streamSession *AudioStreamSession) {
+	botInstance.Queue.mtx.Lock()
+	defer botInstance.Queue.mtx.Unlock()
+	nowPlaying := botInstance.Queue.nowPlaying
+	if nowPlaying == nil || nowPlaying.streamSession != streamSession || len(botInstance.Queue.songs) == 0 {
+		return
+	}
+	song := botInstance.Queue.songs[0]
+	if botInstance.Queue.prefetched != nil {
+		if botInstance.Queue.prefetched.song == song {
+			return
+		}
+		botInstance.discardPrefetched()
+	}
+	log.Printf("[%s | %s] Prefetching song %s",
+		botInstance.GuildId, botInstance.VoiceChannelId, song.SongTitle)
+	botInstance.Queue.prefetched = botInstance.newNowPlaying(song)
+}
+
+// stop prefetched song. Must be called with queue mutex held
+func (botInstance *BotInstance) discardPrefetched() {
+	prefetched := botInstance.Queue.prefetched
+	if prefetched == nil {
+		return
+	}
+	log.Printf("[%s | %s] Discarding prefetched song %s",
+		botInstance.GuildId, botInstance.VoiceChannelId, prefetched.song.SongTitle)
+	prefetched.streamSession.stopStream()
+	prefetched.streamSession.source.remove()
+	botInstance.Queue.prefetched = nil
+}
+
+// crossfade current song into the next song in queue if current song is still
+// the one which is about to end
+func (botInstance *BotInstance) crossfadeNext(streamSession *AudioStreamSession) {
+	botInstance.Queue.mtx.Lock()
+	defer botInstance.Queue.mtx.Unlock()
+	nowPlaying := botInstance.Queue.nowPlaying
+	if nowPlaying == nil || nowPlaying.streamSession != streamSession ||
+		botInstance.Queue.paused || len(botInstance.Queue.songs) == 0 {
+		return
+	}
+	crossfade := botInstance.AudioSettings.getCrossfade()
+	log.Printf("[%s | %s] Crossfading into next song for %s",
+		botInstance.GuildId, botInstance.VoiceChannelId, crossfade.String())
+	botInstance.startNextSong(durationToFrames(crossfade))
+}
+
+// pop next song from queue and start streaming it. The song fades in over
+// fadeFrames while the current song fades out. Must be called with queue
+// mutex held
+func (botInstance *BotInstance) startNextSong(fadeFrames int) {
 	song := botInstance.Queue.songs[0]
 	log.Printf("[%s | %s] Playing song %s",
 		botInstance.GuildId, botInstance.VoiceChannelId, song.SongTitle)
@@ -207,12 +451,33 @@ func (botInstance *BotInstance) playNext() {
 	} else {
 		botInstance.Queue.songs = botInstance.Queue.songs[1:]
 	}
-	done := make(chan error)
-	botInstance.Queue.nowPlaying = &NowPlaying{
-		song:          song,
-		streamSession: NewAudioStream(song, botInstance.BotVoiceConnection, done),
+	// use prefetched song if it is still the next song
+	nowPlaying := botInstance.Queue.prefetched
+	if nowPlaying == nil || nowPlaying.song != song {
+		botInstance.discardPrefetched()
+		nowPlaying = botInstance.newNowPlaying(song)
 	}
+	botInstance.Queue.prefetched = nil
+	// song is held by the mixer while a sound effect interrupts the queue,
+	// which needs it to be decoded
+	if botInstance.Queue.interruptions > 0 {
+		nowPlaying.streamSession.requirePCM()
+	}
+	// songs played often are downloaded to the audio file cache
+	if musicmanager.AudioFiles != nil {
+		go musicmanager.AudioFiles.RecordPlay(song)
+	}
+	botInstance.Mixer.attachSource(nowPlaying.streamSession.source, fadeFrames)
+	botInstance.Queue.nowPlaying = nowPlaying
 	sendCurrentPlayingSongMessage(botInstance, song)
+}
+
+// start stream session for a song which decodes into a mixer source not yet
+// attached to the mixer. Must be called with queue mutex held
+func (botInstance *BotInstance) newNowPlaying(song *common.Song) *NowPlaying {
+	done := make(chan error)
+	streamSession := NewAudioStream(song, newMixerSource(PriorityMusic, 1), botInstance.BotVoiceConnection, botInstance.AudioSettings,
+		botInstance.Queue.prefetch, botInstance.Queue.nearEnd, done)
 
 	go func() {
 		// wait for done channel here
@@ -226,6 +491,22 @@ func (botInstance *BotInstance) playNext() {
 		}
 		botInstance.Queue.mtx.Lock()
 		defer botInstance.Queue.mtx.Unlock()
-		botInstance.Queue.nowPlaying = nil
+		// the song might have been skipped and next song already started
+		if botInstance.Queue.nowPlaying != nil && botInstance.Queue.nowPlaying.streamSession == streamSession {
+			if errors.Is(err, errStreamRecoveryFailed) {
+				sendMessageToChannel(botInstance, common.Boldify(fmt.Sprintf("Couldn't recover stream of '%s'. Skipping to next song", song.SongTitle)))
+			}
+			botInstance.Queue.nowPlaying = nil
+			botInstance.signalNext()
+		}
+		// prefetched song failed before it was played
+		if botInstance.Queue.prefetched != nil && botInstance.Queue.prefetched.streamSession == streamSession {
+			botInstance.Queue.prefetched = nil
+		}
 	}()
+
+	return &NowPlaying{
+		song:          song,
+		streamSession: streamSession,
+	}
 }
@@ -0,0 +1,107 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package bot
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Ar5h71/r4-music-bot/common"
+	"github.com/bwmarrin/discordgo"
+)
+
+// fails requests to discord, so messages of the queue are only logged
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("offline")
+}
+
+// bot instance playing songs with a running mixer and no discord connection
+func newTestBotInstance(t *testing.T) *BotInstance {
+	session, err := discordgo.New(common.BotPrefix + "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Client = &http.Client{Transport: offlineTransport{}}
+	voice := fakeVoiceConnection(t)
+	audioSettings := NewAudioSettings()
+	mixer := NewMixer("[test]", voice, audioSettings.encoderSettings(audioBitRateKbps))
+	go mixer.run()
+	t.Cleanup(mixer.stopMixer)
+	return &BotInstance{
+		BotSession:         session,
+		BotVoiceConnection: voice,
+		GuildId:            "guild",
+		VoiceChannelId:     "voice",
+		TextChannelId:      "text",
+		Queue:              newBotQueue(),
+		AudioSettings:      audioSettings,
+		Mixer:              mixer,
+	}
+}
+
+// song playing in the queue, nil if none is
+func nowPlayingSong(botInstance *BotInstance) *common.Song {
+	botInstance.Queue.mtx.Lock()
+	defer botInstance.Queue.mtx.Unlock()
+	if botInstance.Queue.nowPlaying == nil {
+		return nil
+	}
+	return botInstance.Queue.nowPlaying.song
+}
+
+// wait till the queue is signalled to play next song
+func waitNextSignal(t *testing.T, botInstance *BotInstance) {
+	t.Helper()
+	select {
+	case <-botInstance.Queue.next:
+	case <-time.After(streamTestTimeout):
+		t.Fatalf("timed out waiting for song to end")
+	}
+}
+
+func TestQueuePlaysNextSong(t *testing.T) {
+	fake := newFakeTranscoder(map[string]int{"test://first": 10, "test://second": 10})
+	useTranscoder(t, fake)
+	botInstance := newTestBotInstance(t)
+	first, second := fakeSong("test://first", 10), fakeSong("test://second", 10)
+
+	// the test takes the place of the queue loop started by playQueue
+	botInstance.addSongBack(first, second)
+	botInstance.playNext()
+	if song := nowPlayingSong(botInstance); song != first {
+		t.Fatalf("playing %v, want first song", song)
+	}
+
+	waitNextSignal(t, botInstance)
+	if song := nowPlayingSong(botInstance); song != nil {
+		t.Fatalf("still playing %s after it ended", song.SongTitle)
+	}
+	botInstance.playNext()
+	if song := nowPlayingSong(botInstance); song != second {
+		t.Fatalf("playing %v, want second song", song)
+	}
+	if len(botInstance.Queue.songs) != 0 {
+		t.Fatalf("%d songs left in queue, want none", len(botInstance.Queue.songs))
+	}
+
+	// queue is done once the last song ends
+	waitNextSignal(t, botInstance)
+	botInstance.playNext()
+	select {
+	case <-botInstance.Queue.done:
+	default:
+		t.Fatalf("queue not done after last song")
+	}
+
+	opens := fake.getOpens()
+	if len(opens) != 2 || opens[0].url != first.Stream.Url || opens[1].url != second.Stream.Url {
+		t.Fatalf("songs opened as %+v, want first and then second song", opens)
+	}
+}
@@ -0,0 +1,131 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package bot
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// RMS level in dBFS below which a window is silent
+	silenceThresholdDb = -50
+	// frames in a window over which RMS is measured
+	silenceWindowFrames = 25
+	// song ends once trailing silence lasts this long near its end
+	trailingSilenceDuration = 2 * time.Second
+	// max frames of held back silence kept with their samples. Longer silence
+	// is held without samples and played as digital silence if audio resumes
+	maxHeldSilenceFrames = 500
+)
+
+// mean square of samples below silence threshold
+var silenceMeanSquare = math.Pow(math.MaxInt16*math.Pow(10, silenceThresholdDb/20.0), 2)
+
+// detects silence at start and end of a song from RMS of decoded PCM over
+// windows of frames. Leading silence is skipped. Silence after audio is held
+// back till audio resumes, so that it can be dropped if it lasts till the end
+// of the song
+type silenceDetector struct {
+	// frames of current window and sum of their squared samples
+	window     []bufferedFrame
+	sumSquares float64
+	samples    int
+	// leading silence is being skipped
+	leading bool
+	// frames of silent windows held back as they might be trailing silence.
+	// Frames past maxHeldSilenceFrames have no samples
+	held []bufferedFrame
+	// position in the song before current window and of the last frame
+	windowStart int
+	position    int
+	// frames of leading silence skipped which are not taken yet
+	skipped int
+}
+
+// create a detector for a stream. Leading silence is skipped only if the
+// stream starts at the beginning of the song
+func newSilenceDetector(leading bool) *silenceDetector {
+	return &silenceDetector{leading: leading}
+}
+
+// add a decoded frame with its position in the song. Returns frames to be
+// played, which are held back till their window is known to have audio. If
+// trimming is disabled held frames and the frame are returned as they are
+func (detector *silenceDetector) addFrame(frame []int16, position int, enabled bool) []bufferedFrame {
+	if len(detector.window) == 0 {
+		detector.windowStart = detector.position
+	}
+	detector.position = position
+	detector.window = append(detector.window, bufferedFrame{pcm: frame, position: position})
+	if !enabled {
+		frames := append(detector.held, detector.window...)
+		detector.reset()
+		detector.leading = false
+		return frames
+	}
+
+	for _, sample := range frame {
+		detector.sumSquares += float64(sample) * float64(sample)
+	}
+	detector.samples += len(frame)
+	if len(detector.window) < silenceWindowFrames {
+		return nil
+	}
+
+	silent := detector.sumSquares/float64(detector.samples) < silenceMeanSquare
+	window := detector.window
+	detector.window, detector.sumSquares, detector.samples = nil, 0, 0
+	switch {
+	case detector.leading && silent:
+		detector.skipped += detector.position - detector.windowStart
+		return nil
+	case silent:
+		for _, frame := range window {
+			if len(detector.held) >= maxHeldSilenceFrames {
+				// only position is kept for long silence
+				frame.pcm = nil
+			}
+			detector.held = append(detector.held, frame)
+		}
+		return nil
+	default:
+		// audio has started or resumed, play the held silence and the window
+		detector.leading = false
+		frames := append(detector.held, window...)
+		detector.held = nil
+		return frames
+	}
+}
+
+// drop held frames and current window
+func (detector *silenceDetector) reset() {
+	detector.window, detector.held = nil, nil
+	detector.sumSquares, detector.samples = 0, 0
+}
+
+// get frames still held back once the stream has ended. Silence lasting till
+// the end is dropped, a last window with audio is played with the silence
+// before it
+func (detector *silenceDetector) flush() []bufferedFrame {
+	defer detector.reset()
+	if detector.samples == 0 || detector.sumSquares/float64(detector.samples) < silenceMeanSquare {
+		return nil
+	}
+	return append(detector.held, detector.window...)
+}
+
+// duration of silence held back since audio was last heard
+func (detector *silenceDetector) trailingSilence() time.Duration {
+	return framesToDuration(len(detector.held))
+}
+
+// frames of leading silence skipped since last call
+func (detector *silenceDetector) takeSkipped() int {
+	skipped := detector.skipped
+	detector.skipped = 0
+	return skipped
+}
@@ -0,0 +1,97 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+// decoded frame with all samples set to level
+func levelFrame(level int16) []int16 {
+	frame := make([]int16, framesize*numChannels)
+	for idx := range frame {
+		frame[idx] = level
+	}
+	return frame
+}
+
+// add frames of a level to the detector starting after position. Returns
+// frames to be played and position of the last frame
+func addLevelFrames(detector *silenceDetector, level int16, count, position int) ([]bufferedFrame, int) {
+	frames := make([]bufferedFrame, 0)
+	for idx := 0; idx < count; idx++ {
+		position++
+		frames = append(frames, detector.addFrame(levelFrame(level), position, true)...)
+	}
+	return frames, position
+}
+
+func TestSilenceDetectorHoldsLongSilence(t *testing.T) {
+	detector := newSilenceDetector(false)
+	played, position := addLevelFrames(detector, 10000, silenceWindowFrames, 0)
+	if len(played) != silenceWindowFrames {
+		t.Fatalf("played %d frames of audio, want %d", len(played), silenceWindowFrames)
+	}
+
+	// silence longer than the frames kept with samples is held till audio resumes
+	silentFrames := 2 * maxHeldSilenceFrames
+	held, position := addLevelFrames(detector, 0, silentFrames, position)
+	if len(held) != 0 {
+		t.Fatalf("played %d frames of silence before audio resumed", len(held))
+	}
+	if silence := detector.trailingSilence(); silence != framesToDuration(silentFrames) {
+		t.Fatalf("trailingSilence() = %s, want %s", silence, framesToDuration(silentFrames))
+	}
+	resumed, _ := addLevelFrames(detector, 10000, silenceWindowFrames, position)
+	if len(resumed) != silentFrames+silenceWindowFrames {
+		t.Fatalf("played %d frames once audio resumed, want %d", len(resumed), silentFrames+silenceWindowFrames)
+	}
+	for idx, frame := range resumed {
+		if frame.position != silenceWindowFrames+idx+1 {
+			t.Fatalf("frame %d played at position %d, want %d", idx, frame.position, silenceWindowFrames+idx+1)
+		}
+		if hasSamples := frame.pcm != nil; hasSamples != (idx < maxHeldSilenceFrames || idx >= silentFrames) {
+			t.Fatalf("frame %d has samples: %t", idx, hasSamples)
+		}
+	}
+}
+
+func TestSilenceDetectorDropsTrailingSilence(t *testing.T) {
+	detector := newSilenceDetector(false)
+	_, position := addLevelFrames(detector, 10000, silenceWindowFrames, 0)
+	addLevelFrames(detector, 0, 2*maxHeldSilenceFrames, position)
+	if frames := detector.flush(); len(frames) != 0 {
+		t.Fatalf("played %d frames of silence lasting till the end", len(frames))
+	}
+}
+
+func TestTrailingSilenceEnded(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration time.Duration
+		played   time.Duration
+		silence  time.Duration
+		ended    bool
+	}{
+		{name: "silence till end", duration: time.Minute, played: 40 * time.Second, silence: 18 * time.Second, ended: true},
+		{name: "silence before end", duration: time.Minute, played: 40 * time.Second, silence: 10 * time.Second},
+		{name: "short silence at end", duration: time.Minute, played: 59 * time.Second, silence: time.Second},
+		{name: "unknown duration", played: 40 * time.Second, silence: time.Minute},
+	}
+	for _, test := range tests {
+		audioStream := &AudioStreamSession{
+			song:         fakeSong("test://song", durationToFrames(test.duration)),
+			framesPlayed: durationToFrames(test.played),
+			// decoding runs ahead of playback while silence is held
+			framesSent: durationToFrames(test.played + test.silence),
+			speed:      1,
+		}
+		if ended := audioStream.trailingSilenceEnded(test.silence); ended != test.ended {
+			t.Errorf("%s: trailingSilenceEnded() = %t, want %t", test.name, ended, test.ended)
+		}
+	}
+}
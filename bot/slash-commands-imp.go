@@ -6,6 +6,8 @@ import (
 	"log"
 	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Ar5h71/r4-music-bot/common"
 	"github.com/Ar5h71/r4-music-bot/musicmanager"
@@ -76,7 +78,9 @@ func createAndGetBotInstance(session *discordgo.Session, interaction *discordgo.
 	return botInstance, nil
 }
 
-func PlayCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate, playNow bool) (*common.Song, error) {
+// play a song, or all songs of a playlist url. Playlist is nil if a single
+// song was added
+func PlayCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate, playNow bool) (*common.Song, *musicmanager.Playlist, error) {
 	options := interaction.ApplicationCommandData().Options
 	guildId := interaction.GuildID
 	vChannelId := SearchVoiceChannelId(interaction.Member.User.ID)
@@ -86,50 +90,452 @@ func PlayCommandHandler(session *discordgo.Session, interaction *discordgo.Inter
 	// create bot instance and connect to voice channel if not there
 	botInstance, err := createAndGetBotInstance(session, interaction, true)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	option := options[0]
+	optionMap := make(map[string]*discordgo.ApplicationCommandInteractionDataOption, len(options))
+	for _, option := range options {
+		optionMap[option.Name] = option
+	}
+	songQuery := optionMap[SongQueryOrUrlOptionName].StringValue()
 
-	log.Printf("%s Got option: [%s]", logCtx, option.StringValue())
+	log.Printf("%s Got option: [%s]", logCtx, songQuery)
+
+	var startAt time.Duration
+	var timestamp string
+	timestampOption, hasTimestamp := optionMap[TimestampOptionName]
+	if hasTimestamp {
+		timestamp = timestampOption.StringValue()
+		startAt, err = common.ParseTimestamp(timestamp)
+		if err != nil {
+			log.Printf("%s Failed to parse timestamp '%s'. Got error: %s", logCtx, timestamp, err.Error())
+			return nil, nil, fmt.Errorf("Please specify timestamp as mm:ss")
+		}
+	}
 
 	var song *common.Song
+	var playlist *musicmanager.Playlist
 
 	// check if option received is url
-	_, err = url.ParseRequestURI(option.StringValue())
-	if err == nil {
-		log.Printf("%s Received option is a URL: [%s]", logCtx, option.StringValue())
-		song, err = musicmanager.GetSongWithStreamUrl(option.StringValue(), interaction.Member.User.Username)
+	_, err = url.ParseRequestURI(songQuery)
+	if err == nil && musicmanager.IsPlaylistUrl(songQuery) {
+		log.Printf("%s Received option is a playlist URL: [%s]", logCtx, songQuery)
+		if hasTimestamp {
+			return nil, nil, fmt.Errorf("Timestamp can't be used with playlists")
+		}
+		limit := DefaultPlaylistSongs
+		if option, ok := optionMap[PlaylistLimitOptionName]; ok {
+			limit = int(option.IntValue())
+		}
+		shuffle := false
+		if option, ok := optionMap[ShuffleOptionName]; ok {
+			shuffle = option.BoolValue()
+		}
+		playlist, err = musicmanager.ResolvePlaylist(songQuery, interaction.Member.User.Username, limit, shuffle)
 		if err != nil {
-			errMsg := fmt.Sprintf("Couldn't find song for the requested URL '%s'", option.StringValue())
+			errMsg := fmt.Sprintf("Couldn't add songs of the playlist '%s'", songQuery)
 			log.Printf("%s error [%s]", logCtx, err.Error())
-			return nil, fmt.Errorf(errMsg)
+			return nil, nil, fmt.Errorf(errMsg)
+		}
+		log.Printf("%s Adding %d songs of playlist '%s', %d unavailable", logCtx, len(playlist.Songs),
+			playlist.Title, playlist.Unavailable)
+		song = playlist.Songs[0]
+	} else if err == nil && !musicmanager.HasSourcePrefix(songQuery) {
+		log.Printf("%s Received option is a URL: [%s]", logCtx, songQuery)
+		song, err = musicmanager.Resolve(songQuery, interaction.Member.User.Username)
+		if err != nil {
+			errMsg := fmt.Sprintf("Couldn't find song for the requested URL '%s'", songQuery)
+			log.Printf("%s error [%s]", logCtx, err.Error())
+			return nil, nil, fmt.Errorf(errMsg)
 		}
 	} else {
 
-		// search youtube for song
-		songs, err := musicmanager.YtServiceClient.Search(option.StringValue(), interaction.Member.User.Username, 1)
+		// search music sources for song
+		songs, err := musicmanager.Search(songQuery, interaction.Member.User.Username, 1)
 
 		if err != nil {
-			errMsg := fmt.Sprintf("Couldn't find the song for query '%s'", option.StringValue())
+			errMsg := fmt.Sprintf("Couldn't find the song for query '%s'", songQuery)
 			log.Printf("%s, error: [%s]", errMsg, err.Error())
-			return nil, fmt.Errorf(errMsg)
+			return nil, nil, fmt.Errorf(errMsg)
 		}
 		song = songs[0]
 	}
 
+	// timestamp option takes precedence over timestamp in url
+	if hasTimestamp {
+		// duration of live streams and some files is unknown
+		if song.SongDuration > 0 && startAt >= song.SongDuration {
+			log.Printf("%s Timestamp %s is beyond song duration %s", logCtx, startAt.String(), song.SongDuration.String())
+			return nil, nil, fmt.Errorf("Timestamp '%s' is beyond song duration '%s'", timestamp, song.SongDuration.String())
+		}
+		song.StartAt = startAt
+	}
+
 	botInstance.BotVoiceConnection.LogLevel = discordgo.LogWarning
-	// send signal to songsig channel
-	songSig <- &SongSignal{
+	// send signal to songsig channel. Rest of a playlist is queued after
+	// its first song
+	songSignal := &SongSignal{
 		song:        song,
 		botInstance: botInstance,
 		playNow:     playNow,
 	}
+	if playlist != nil {
+		songSignal.more = playlist.Songs[1:]
+	}
+	songSig <- songSignal
 	// send skip signal if playNow is true
 	if playNow && botInstance.Queue.nowPlaying != nil {
 		botInstance.Queue.skip <- nil
 	}
-	return song, nil
+	return song, playlist, nil
+}
+
+func SeekCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) (time.Duration, error) {
+	options := interaction.ApplicationCommandData().Options
+	guildId := interaction.GuildID
+	vChannelId := SearchVoiceChannelId(interaction.Member.User.ID)
+	logCtx := fmt.Sprintf("[%s | %s]", guildId, vChannelId)
+	log.Printf("%s 'Seek' command received", logCtx)
+
+	botInstance, err := createAndGetBotInstance(session, interaction, false)
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := options[0].StringValue()
+	position, err := common.ParseTimestamp(timestamp)
+	if err != nil {
+		log.Printf("%s Failed to parse timestamp '%s'. Got error: %s", logCtx, timestamp, err.Error())
+		return 0, fmt.Errorf("Please specify timestamp as mm:ss")
+	}
+
+	botInstance.Queue.mtx.Lock()
+	nowPlaying := botInstance.Queue.nowPlaying
+	botInstance.Queue.mtx.Unlock()
+	if nowPlaying == nil {
+		return 0, errors.New("No song is playing. Nothing to seek")
+	}
+	if nowPlaying.song.SongDuration > 0 && position >= nowPlaying.song.SongDuration {
+		log.Printf("%s Timestamp %s is beyond song duration %s", logCtx, position.String(), nowPlaying.song.SongDuration.String())
+		return 0, fmt.Errorf("Timestamp '%s' is beyond song duration '%s'", timestamp, nowPlaying.song.SongDuration.String())
+	}
+
+	// send signal on seek channel
+	botInstance.Queue.seek <- position
+	return position, nil
+}
+
+func VolumeCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) (int, error) {
+	options := interaction.ApplicationCommandData().Options
+	guildId := interaction.GuildID
+	vChannelId := SearchVoiceChannelId(interaction.Member.User.ID)
+	log.Printf("[%s | %s]. 'Volume' command received", guildId, vChannelId)
+
+	botInstance, err := createAndGetBotInstance(session, interaction, false)
+	if err != nil {
+		return 0, err
+	}
+
+	volume := int(options[0].IntValue())
+	if volume < MinVolume || volume > MaxVolume {
+		return 0, fmt.Errorf("Volume should be between %d and %d", MinVolume, MaxVolume)
+	}
+	botInstance.setVolume(volume)
+	return volume, nil
+}
+
+func CrossfadeCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) (time.Duration, error) {
+	options := interaction.ApplicationCommandData().Options
+	guildId := interaction.GuildID
+	vChannelId := SearchVoiceChannelId(interaction.Member.User.ID)
+	log.Printf("[%s | %s]. 'Crossfade' command received", guildId, vChannelId)
+
+	botInstance, err := createAndGetBotInstance(session, interaction, false)
+	if err != nil {
+		return 0, err
+	}
+
+	seconds := int(options[0].IntValue())
+	if seconds < 0 || seconds > MaxCrossfadeSeconds {
+		return 0, fmt.Errorf("Crossfade should be between 0 and %d seconds", MaxCrossfadeSeconds)
+	}
+	crossfade := time.Duration(seconds) * time.Second
+	botInstance.setCrossfade(crossfade)
+	return crossfade, nil
+}
+
+func NormalizeCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) (bool, int, error) {
+	options := interaction.ApplicationCommandData().Options
+	guildId := interaction.GuildID
+	vChannelId := SearchVoiceChannelId(interaction.Member.User.ID)
+	log.Printf("[%s | %s]. 'Normalize' command received", guildId, vChannelId)
+
+	botInstance, err := createAndGetBotInstance(session, interaction, false)
+	if err != nil {
+		return false, 0, err
+	}
+
+	optionMap := make(map[string]*discordgo.ApplicationCommandInteractionDataOption, len(options))
+	for _, option := range options {
+		optionMap[option.Name] = option
+	}
+	normalize := optionMap[EnabledOptionName].BoolValue()
+	_, targetLoudness := botInstance.AudioSettings.getNormalization()
+	if option, ok := optionMap[TargetLoudnessOptionName]; ok {
+		targetLoudness = int(option.IntValue())
+	}
+	if targetLoudness < MinTargetLoudness || targetLoudness > MaxTargetLoudness {
+		return false, 0, fmt.Errorf("Target loudness should be between %d and %d LUFS", MinTargetLoudness, MaxTargetLoudness)
+	}
+	botInstance.setNormalization(normalize, targetLoudness)
+	return normalize, targetLoudness, nil
+}
+
+func EqCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) ([eqBands]float64, string, error) {
+	options := interaction.ApplicationCommandData().Options
+	guildId := interaction.GuildID
+	vChannelId := SearchVoiceChannelId(interaction.Member.User.ID)
+	log.Printf("[%s | %s]. 'Eq' command received", guildId, vChannelId)
+
+	botInstance, err := createAndGetBotInstance(session, interaction, false)
+	if err != nil {
+		return [eqBands]float64{}, "", err
+	}
+
+	var curve string
+	var bandFrequency int
+	var gainDb float64
+	hasBand, hasGain := false, false
+	for _, option := range options {
+		switch option.Name {
+		case EqCurveOptionName:
+			curve = option.StringValue()
+		case EqBandOptionName:
+			bandFrequency = int(option.IntValue())
+			hasBand = true
+		case EqGainOptionName:
+			gainDb = float64(option.IntValue())
+			hasGain = true
+		}
+	}
+	if len(options) == 0 {
+		gains, curve := botInstance.AudioSettings.getEqualizer()
+		return gains, curve, nil
+	}
+	if _, ok := eqCurves[curve]; curve != "" && !ok {
+		return [eqBands]float64{}, "", fmt.Errorf("Couldn't find equalizer curve '%s'", curve)
+	}
+	if hasBand != hasGain {
+		return [eqBands]float64{}, "", fmt.Errorf("Both band and gain are needed to set a band")
+	}
+	if _, ok := eqBandIndex(bandFrequency); hasBand && !ok {
+		return [eqBands]float64{}, "", fmt.Errorf("Equalizer has no band at %dHz", bandFrequency)
+	}
+	if gainDb < MinEqGainDb || gainDb > MaxEqGainDb {
+		return [eqBands]float64{}, "", fmt.Errorf("Gain should be between %d and %d dB", MinEqGainDb, MaxEqGainDb)
+	}
+	gains, curve := botInstance.setEqualizer(curve, bandFrequency, gainDb)
+	return gains, curve, nil
+}
+
+func SpeedCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) (float64, error) {
+	options := interaction.ApplicationCommandData().Options
+	guildId := interaction.GuildID
+	vChannelId := SearchVoiceChannelId(interaction.Member.User.ID)
+	log.Printf("[%s | %s]. 'Speed' command received", guildId, vChannelId)
+
+	botInstance, err := createAndGetBotInstance(session, interaction, false)
+	if err != nil {
+		return 0, err
+	}
+
+	speed := options[0].FloatValue()
+	if speed < MinSpeed || speed > MaxSpeed {
+		return 0, fmt.Errorf("Speed should be between %.1fx and %.1fx", MinSpeed, MaxSpeed)
+	}
+	botInstance.setSpeed(speed)
+	return speed, nil
+}
+
+func PitchCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) (int, error) {
+	options := interaction.ApplicationCommandData().Options
+	guildId := interaction.GuildID
+	vChannelId := SearchVoiceChannelId(interaction.Member.User.ID)
+	log.Printf("[%s | %s]. 'Pitch' command received", guildId, vChannelId)
+
+	botInstance, err := createAndGetBotInstance(session, interaction, false)
+	if err != nil {
+		return 0, err
+	}
+
+	pitch := int(options[0].IntValue())
+	if pitch < MinPitch || pitch > MaxPitch {
+		return 0, fmt.Errorf("Pitch should be between %d and %d semitones", MinPitch, MaxPitch)
+	}
+	botInstance.setPitch(pitch)
+	return pitch, nil
+}
+
+func TrimSilenceCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) (bool, error) {
+	options := interaction.ApplicationCommandData().Options
+	guildId := interaction.GuildID
+	vChannelId := SearchVoiceChannelId(interaction.Member.User.ID)
+	log.Printf("[%s | %s]. 'Trim silence' command received", guildId, vChannelId)
+
+	botInstance, err := createAndGetBotInstance(session, interaction, false)
+	if err != nil {
+		return false, err
+	}
+
+	trimSilence := options[0].BoolValue()
+	botInstance.setTrimSilence(trimSilence)
+	return trimSilence, nil
+}
+
+func NowPlayingCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) (*common.Song, time.Duration, float64, bool, error) {
+	guildId := interaction.GuildID
+	vChannelId := SearchVoiceChannelId(interaction.Member.User.ID)
+	log.Printf("[%s | %s]. 'Now playing' command received", guildId, vChannelId)
+
+	botInstance, err := createAndGetBotInstance(session, interaction, false)
+	if err != nil {
+		return nil, 0, 0, false, err
+	}
+	return botInstance.nowPlayingStatus()
+}
+
+func AudioQualityCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) (EncoderSettings, error) {
+	options := interaction.ApplicationCommandData().Options
+	guildId := interaction.GuildID
+	vChannelId := SearchVoiceChannelId(interaction.Member.User.ID)
+	log.Printf("[%s | %s]. 'Audio quality' command received", guildId, vChannelId)
+
+	botInstance, err := createAndGetBotInstance(session, interaction, false)
+	if err != nil {
+		return EncoderSettings{}, err
+	}
+
+	// options which are not given keep their current value
+	bitrateKbps, fec, packetLossPercent := botInstance.AudioSettings.getEncoderQuality()
+	for _, option := range options {
+		switch option.Name {
+		case BitrateOptionName:
+			bitrateKbps = int(option.IntValue())
+		case FecOptionName:
+			fec = option.BoolValue()
+		case PacketLossOptionName:
+			packetLossPercent = int(option.IntValue())
+		}
+	}
+	if bitrateKbps != 0 && (bitrateKbps < MinBitrateKbps || bitrateKbps > MaxBitrateKbps) {
+		return EncoderSettings{}, fmt.Errorf("Bitrate should be 0 or between %d and %d kbps", MinBitrateKbps, MaxBitrateKbps)
+	}
+	if packetLossPercent < 0 || packetLossPercent > MaxPacketLossPercent {
+		return EncoderSettings{}, fmt.Errorf("Packet loss should be between 0 and %d percent", MaxPacketLossPercent)
+	}
+	return botInstance.setAudioQuality(bitrateKbps, fec, packetLossPercent), nil
+}
+
+func SfxCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) (string, error) {
+	options := interaction.ApplicationCommandData().Options
+	guildId := interaction.GuildID
+	vChannelId := SearchVoiceChannelId(interaction.Member.User.ID)
+	log.Printf("[%s | %s]. 'Sfx' command received", guildId, vChannelId)
+
+	botInstance, err := createAndGetBotInstance(session, interaction, false)
+	if err != nil {
+		return "", err
+	}
+
+	optionMap := make(map[string]*discordgo.ApplicationCommandInteractionDataOption, len(options))
+	for _, option := range options {
+		optionMap[option.Name] = option
+	}
+	name := optionMap[SfxOptionName].StringValue()
+	mode := SfxModeOverlay
+	if option, ok := optionMap[SfxModeOptionName]; ok {
+		mode = option.StringValue()
+	}
+	volume := DefaultVolume
+	if option, ok := optionMap[VolumeOptionName]; ok {
+		volume = int(option.IntValue())
+	}
+	if volume < MinVolume || volume > MaxVolume {
+		return "", fmt.Errorf("Volume should be between %d and %d", MinVolume, MaxVolume)
+	}
+	err = botInstance.playSoundEffect(name, mode, volume)
+	if err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// suggest sound effects matching the typed value
+func SfxAutocompleteHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) []*discordgo.ApplicationCommandOptionChoice {
+	typed := ""
+	for _, option := range interaction.ApplicationCommandData().Options {
+		if option.Focused {
+			typed = strings.ToLower(option.StringValue())
+		}
+	}
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0)
+	for _, name := range listSoundEffects() {
+		// discord allows at most 25 choices
+		if len(choices) == 25 {
+			break
+		}
+		if strings.Contains(strings.ToLower(name), typed) {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+				Name:  name,
+				Value: name,
+			})
+		}
+	}
+	return choices
+}
+
+func FilterCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) ([]string, error) {
+	options := interaction.ApplicationCommandData().Options
+	guildId := interaction.GuildID
+	vChannelId := SearchVoiceChannelId(interaction.Member.User.ID)
+	log.Printf("[%s | %s]. 'Filter' command received", guildId, vChannelId)
+
+	botInstance, err := createAndGetBotInstance(session, interaction, false)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.ToLower(options[0].StringValue())
+	if _, ok := getFilterPreset(name); !ok && name != FilterOff {
+		return nil, fmt.Errorf("Unknown filter '%s'", name)
+	}
+	return botInstance.toggleFilter(name), nil
+}
+
+// suggest filter presets matching the typed value
+func FilterAutocompleteHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) []*discordgo.ApplicationCommandOptionChoice {
+	typed := ""
+	for _, option := range interaction.ApplicationCommandData().Options {
+		if option.Focused {
+			typed = strings.ToLower(option.StringValue())
+		}
+	}
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0)
+	for _, preset := range filterPresets {
+		if strings.HasPrefix(preset.Name, typed) {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+				Name:  fmt.Sprintf("%s - %s", preset.Name, preset.Description),
+				Value: preset.Name,
+			})
+		}
+	}
+	if strings.HasPrefix(FilterOff, typed) {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  fmt.Sprintf("%s - Remove all filters", FilterOff),
+			Value: FilterOff,
+		})
+	}
+	return choices
 }
 
 func PauseCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) error {
@@ -187,21 +593,15 @@ func SearchCommandHandler(session *discordgo.Session, interaction *discordgo.Int
 
 	log.Printf("%s Got option: [%s]", logCtx, option.StringValue())
 
-	// search youtube for song
-	songs, err := musicmanager.YtServiceClient.Search(option.StringValue(), interaction.Member.User.Username, 10)
-
+	// search music sources for songs. Results of sources registered first
+	// are listed first
+	songs, err := musicmanager.Search(option.StringValue(), interaction.Member.User.Username, maxSearchResults)
 	if err != nil {
 		errMsg := fmt.Sprintf("Couldn't find the songs for query '%s'", option.StringValue())
 		log.Printf("%s, error: [%s]", errMsg, err.Error())
 		return nil, fmt.Errorf(errMsg)
 	}
 
-	if len(songs) == 0 {
-		errMsg := fmt.Sprintf("Couldn't find the songs for query '%s'", option.StringValue())
-		log.Printf("%s", errMsg)
-		return nil, fmt.Errorf(errMsg)
-	}
-
 	return songs, nil
 }
 
@@ -314,9 +714,9 @@ func AutofillCommandHandler(session *discordgo.Session, interaction *discordgo.I
 
 	// check if option received is url
 	_, err = url.ParseRequestURI(songQuery)
-	if err == nil {
+	if err == nil && !musicmanager.HasSourcePrefix(songQuery) {
 		log.Printf("%s Received option is a URL: [%s]", logCtx, songQuery)
-		song, err = musicmanager.GetSongWithStreamUrl(songQuery, interaction.Member.User.Username)
+		song, err = musicmanager.Resolve(songQuery, interaction.Member.User.Username)
 		if err != nil {
 			errMsg := fmt.Sprintf("Couldn't find song for the requested URL '%s'", songQuery)
 			log.Printf("%s error [%s]", logCtx, err.Error())
@@ -324,21 +724,20 @@ func AutofillCommandHandler(session *discordgo.Session, interaction *discordgo.I
 		}
 	} else {
 
-		// search youtube for song
-		songs, err := musicmanager.YtServiceClient.Search(songQuery, interaction.Member.User.Username, 1)
-		song = songs[0]
-
+		// search music sources for song
+		songs, err := musicmanager.Search(songQuery, interaction.Member.User.Username, 1)
 		if err != nil {
 			errMsg := fmt.Sprintf("Couldn't find the song for query '%s'", songQuery)
 			log.Printf("%s, error: [%s]", errMsg, err.Error())
 			return botInstance, nil, fmt.Errorf(errMsg)
 		}
+		song = songs[0]
 	}
 
 	botInstance.BotVoiceConnection.LogLevel = discordgo.LogWarning
 
 	// search songs related to queried song
-	songs, err := musicmanager.YtServiceClient.SearchRelavantSongs(song.SongId, song.User, int64(songNum))
+	songs, err := musicmanager.Related(song, song.User, songNum)
 	if err != nil {
 		log.Printf("[%s] Failed to get relevant songs for song [%s | %s]", logCtx, song.SongTitle, song.SongId)
 		return botInstance, nil, fmt.Errorf("Failed to generate queue")
@@ -366,3 +765,10 @@ func AutofillCommandHandler(session *discordgo.Session, interaction *discordgo.I
 
 	return botInstance, songsInQueue, nil
 }
+
+func CacheStatsCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) musicmanager.CacheStats {
+	guildId := interaction.GuildID
+	vChannelId := SearchVoiceChannelId(interaction.Member.User.ID)
+	log.Printf("[%s | %s]. 'Cache stats' command received", guildId, vChannelId)
+	return musicmanager.Cache.Stats()
+}
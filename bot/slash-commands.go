@@ -9,6 +9,8 @@ package bot
 
 import (
 	"fmt"
+	"log"
+	"strings"
 	"time"
 
 	"github.com/Ar5h71/r4-music-bot/common"
@@ -17,15 +19,28 @@ import (
 
 // command name constants
 const (
-	PlayCommand      = "play"
-	PlayNowCommand   = "play-now"
-	PauseCommand     = "pause"
-	SkipCommand      = "skip"
-	ShowQueueCommand = "show-queue"
-	StopQueueCommand = "stop-queue"
-	ResumeCommand    = "resume"
-	SearchCommand    = "search"
-	AutofillCommand  = "autofill"
+	PlayCommand         = "play"
+	PlayNowCommand      = "play-now"
+	PauseCommand        = "pause"
+	SkipCommand         = "skip"
+	ShowQueueCommand    = "show-queue"
+	StopQueueCommand    = "stop-queue"
+	ResumeCommand       = "resume"
+	SearchCommand       = "search"
+	AutofillCommand     = "autofill"
+	SeekCommand         = "seek"
+	VolumeCommand       = "volume"
+	FilterCommand       = "filter"
+	CrossfadeCommand    = "crossfade"
+	SfxCommand          = "sfx"
+	NormalizeCommand    = "normalize"
+	AudioQualityCommand = "audio-quality"
+	NowPlayingCommand   = "nowplaying"
+	EqCommand           = "eq"
+	SpeedCommand        = "speed"
+	PitchCommand        = "pitch"
+	TrimSilenceCommand  = "trim-silence"
+	CacheStatsCommand   = "cache-stats"
 )
 
 // option name constants
@@ -34,17 +49,48 @@ const (
 	SongQueryOptionName      = "song-query"
 	TimestampOptionName      = "timestamp"
 	SongNumOption            = "song-num"
+	VolumeOptionName         = "level"
+	FilterOptionName         = "preset"
+	SecondsOptionName        = "seconds"
+	SfxOptionName            = "name"
+	SfxModeOptionName        = "mode"
+	EnabledOptionName        = "enabled"
+	TargetLoudnessOptionName = "target"
+	BitrateOptionName        = "bitrate"
+	FecOptionName            = "fec"
+	PacketLossOptionName     = "packet-loss"
+	EqCurveOptionName        = "curve"
+	EqBandOptionName         = "band"
+	EqGainOptionName         = "gain"
+	SpeedOptionName          = "factor"
+	PitchOptionName          = "semitones"
+	ShuffleOptionName        = "shuffle"
+	PlaylistLimitOptionName  = "limit"
 )
 
 // constants for responses
 const (
-	InternalServerError = "Internal Server Error"
-	SkipTrack           = "Skipping current playing track"
-	PauseTrack          = "Pausing current playing track"
-	ResumeTrack         = "Resuming current paused track"
-	StopQueue           = "Stopping queue. Removing all tracks"
-	ShowQueue           = "Checking all songs in queue"
-	Autofill            = "Successfully generated playlist"
+	InternalServerError  = "Internal Server Error"
+	SkipTrack            = "Skipping current playing track"
+	PauseTrack           = "Pausing current playing track"
+	ResumeTrack          = "Resuming current paused track"
+	StopQueue            = "Stopping queue. Removing all tracks"
+	ShowQueue            = "Checking all songs in queue"
+	Autofill             = "Successfully generated playlist"
+	SeekTrack            = "Seeking current track to %s"
+	SetVolume            = "Setting volume to %d%%"
+	ActiveFilters        = "Active filters: %s"
+	NoActiveFilters      = "No active filters"
+	SetCrossfade         = "Setting crossfade between songs to %s"
+	DisableCrossfade     = "Disabling crossfade between songs"
+	PlaySfx              = "Playing sound effect '%s'"
+	EnableNormalization  = "Normalizing loudness of songs to %d LUFS"
+	DisableNormalization = "Disabling loudness normalization"
+	AudioQuality         = "Encoding audio at %dkbps with FEC %s and expected packet loss of %d%%"
+	SetSpeed             = "Setting playback speed to %.2fx"
+	SetPitch             = "Shifting pitch by %+d semitones"
+	EnableTrimSilence    = "Trimming silence at start and end of songs"
+	DisableTrimSilence   = "Disabling silence trimming"
 )
 
 // constants for search command
@@ -56,9 +102,29 @@ const (
 // general constants
 const (
 	DefaultSongsForAutofill = 20
+	// max songs listed by search command
+	maxSearchResults = 10
+	// songs added from a playlist url by default and at most
+	DefaultPlaylistSongs = 50
+	MaxPlaylistSongs     = 100
 )
 
 var (
+	// min value for option needs to be a pointer
+	minVolumeOption    float64 = MinVolume
+	minCrossfadeOption float64 = 0
+	minLoudnessOption  float64 = MinTargetLoudness
+	minBitrateOption   float64 = 0
+	minPacketLoss      float64 = 0
+	minEqGainOption    float64 = MinEqGainDb
+	minSpeedOption     float64 = MinSpeed
+	minPitchOption     float64 = MinPitch
+
+	minPlaylistLimitOption float64 = 1
+
+	// admin commands need manage server permission
+	adminPermissions int64 = discordgo.PermissionManageServer
+
 	// commands need to defined in slice of 'ApplicationCommand' struct
 	// check 'https://github.com/bwmarrin/discordgo/blob/master/examples/slash_commands/main.go'
 	commands = []*discordgo.ApplicationCommand{
@@ -72,6 +138,26 @@ var (
 					Description: "Query or URL for song to be played.",
 					Required:    true,
 				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        TimestampOptionName,
+					Description: "Timestamp to start the song from, e.g. 1:30",
+					Required:    false,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        ShuffleOptionName,
+					Description: "Shuffle songs of a playlist URL",
+					Required:    false,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        PlaylistLimitOptionName,
+					Description: "Max songs added from a playlist URL. Default is 50",
+					Required:    false,
+					MinValue:    &minPlaylistLimitOption,
+					MaxValue:    MaxPlaylistSongs,
+				},
 			},
 		},
 		{
@@ -84,6 +170,26 @@ var (
 					Description: "Query or URL for song to be played.",
 					Required:    true,
 				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        TimestampOptionName,
+					Description: "Timestamp to start the song from, e.g. 1:30",
+					Required:    false,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        ShuffleOptionName,
+					Description: "Shuffle songs of a playlist URL",
+					Required:    false,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        PlaylistLimitOptionName,
+					Description: "Max songs added from a playlist URL. Default is 50",
+					Required:    false,
+					MinValue:    &minPlaylistLimitOption,
+					MaxValue:    MaxPlaylistSongs,
+				},
 			},
 		},
 		{
@@ -136,6 +242,222 @@ var (
 				},
 			},
 		},
+		{
+			Name:        SeekCommand,
+			Description: "Seek current playing song to a timestamp.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        TimestampOptionName,
+					Description: "Timestamp to seek to, e.g. 1:30",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Name:        VolumeCommand,
+			Description: "Set volume for the queue.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        VolumeOptionName,
+					Description: "Volume in percent from 0 to 200",
+					Required:    true,
+					MinValue:    &minVolumeOption,
+					MaxValue:    MaxVolume,
+				},
+			},
+		},
+		{
+			Name:        FilterCommand,
+			Description: "Toggle an audio filter preset. Filters can be combined.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         FilterOptionName,
+					Description:  "Filter preset to toggle, or 'off' to remove all filters",
+					Required:     true,
+					Autocomplete: true,
+				},
+			},
+		},
+		{
+			Name:        CrossfadeCommand,
+			Description: "Crossfade between consecutive songs in queue. 0 disables crossfade.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        SecondsOptionName,
+					Description: "Crossfade duration in seconds from 0 to 12",
+					Required:    true,
+					MinValue:    &minCrossfadeOption,
+					MaxValue:    MaxCrossfadeSeconds,
+				},
+			},
+		},
+		{
+			Name:        SfxCommand,
+			Description: "Play a sound effect.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         SfxOptionName,
+					Description:  "Name of the sound effect",
+					Required:     true,
+					Autocomplete: true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        SfxModeOptionName,
+					Description: "Mix with current song or hold it while sound effect is played",
+					Required:    false,
+					Choices: []*discordgo.ApplicationCommandOptionChoice{
+						{
+							Name:  SfxModeOverlay,
+							Value: SfxModeOverlay,
+						},
+						{
+							Name:  SfxModeInterrupt,
+							Value: SfxModeInterrupt,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        VolumeOptionName,
+					Description: "Volume in percent of the queue volume from 0 to 200. Default is 100",
+					Required:    false,
+					MinValue:    &minVolumeOption,
+					MaxValue:    MaxVolume,
+				},
+			},
+		},
+		{
+			Name:        NormalizeCommand,
+			Description: "Normalize loudness of songs in queue.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        EnabledOptionName,
+					Description: "Enable or disable loudness normalization",
+					Required:    true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        TargetLoudnessOptionName,
+					Description: "Target loudness in LUFS from -30 to -5. Default is -14",
+					Required:    false,
+					MinValue:    &minLoudnessOption,
+					MaxValue:    MaxTargetLoudness,
+				},
+			},
+		},
+		{
+			Name:        EqCommand,
+			Description: "Set equalizer to a saved curve or set gain of a band. Shows equalizer without options.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        EqCurveOptionName,
+					Description: "Saved equalizer curve",
+					Required:    false,
+					Choices:     eqCurveChoices(),
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        EqBandOptionName,
+					Description: "Centre frequency of the band in Hz",
+					Required:    false,
+					Choices:     eqBandChoices(),
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        EqGainOptionName,
+					Description: "Gain of the band in dB from -12 to 12",
+					Required:    false,
+					MinValue:    &minEqGainOption,
+					MaxValue:    MaxEqGainDb,
+				},
+			},
+		},
+		{
+			Name:        SpeedCommand,
+			Description: "Set playback speed of songs without changing their pitch.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionNumber,
+					Name:        SpeedOptionName,
+					Description: "Speed factor from 0.5 to 2. Default is 1",
+					Required:    true,
+					MinValue:    &minSpeedOption,
+					MaxValue:    MaxSpeed,
+				},
+			},
+		},
+		{
+			Name:        PitchCommand,
+			Description: "Shift pitch of songs without changing their speed.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        PitchOptionName,
+					Description: "Pitch shift in semitones from -12 to 12. Default is 0",
+					Required:    true,
+					MinValue:    &minPitchOption,
+					MaxValue:    MaxPitch,
+				},
+			},
+		},
+		{
+			Name:        TrimSilenceCommand,
+			Description: "Skip silence at start and end of songs.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        EnabledOptionName,
+					Description: "Enable or disable silence trimming",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Name:        NowPlayingCommand,
+			Description: "Show current playing song with elapsed time.",
+		},
+		{
+			Name:                     AudioQualityCommand,
+			Description:              "Configure opus encoding of audio sent to voice channel.",
+			DefaultMemberPermissions: &adminPermissions,
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        BitrateOptionName,
+					Description: "Bitrate in kbps from 8 to 384. 0 matches bitrate of the voice channel",
+					Required:    false,
+					MinValue:    &minBitrateOption,
+					MaxValue:    MaxBitrateKbps,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        FecOptionName,
+					Description: "Enable in-band forward error correction",
+					Required:    false,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        PacketLossOptionName,
+					Description: "Expected packet loss in percent from 0 to 100",
+					Required:    false,
+					MinValue:    &minPacketLoss,
+					MaxValue:    MaxPacketLossPercent,
+				},
+			},
+		},
+		{
+			Name:                     CacheStatsCommand,
+			Description:              "Show hits and misses of youtube metadata cache.",
+			DefaultMemberPermissions: &adminPermissions,
+		},
 	}
 
 	// command handlers for command definitions
@@ -144,7 +466,7 @@ var (
 			session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
 				Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
 			})
-			song, err := PlayCommandHandler(session, interaction, false)
+			song, playlist, err := PlayCommandHandler(session, interaction, false)
 
 			if err != nil {
 				msg := fmt.Sprintf("`%s`", err.Error())
@@ -154,13 +476,17 @@ var (
 				return
 			}
 
+			if playlist != nil {
+				addPlaylistToQueueInteractionResponse(session, interaction, playlist, false)
+				return
+			}
 			addToQueueInteractionResponse(session, interaction, song, false)
 		},
 		PlayNowCommand: func(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
 			session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
 				Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
 			})
-			song, err := PlayCommandHandler(session, interaction, true)
+			song, playlist, err := PlayCommandHandler(session, interaction, true)
 
 			if err != nil {
 				msg := common.Boldify(err.Error())
@@ -170,6 +496,10 @@ var (
 				return
 			}
 
+			if playlist != nil {
+				addPlaylistToQueueInteractionResponse(session, interaction, playlist, true)
+				return
+			}
 			addToQueueInteractionResponse(session, interaction, song, true)
 		},
 		PauseCommand: func(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
@@ -328,6 +658,286 @@ var (
 			}
 
 		},
+		SeekCommand: func(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+			session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			})
+			position, err := SeekCommandHandler(session, interaction)
+
+			if err != nil {
+				msg := common.Boldify(err.Error())
+				session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+					Content: &msg,
+				})
+				return
+			}
+
+			msg := common.Boldify(fmt.Sprintf(SeekTrack, position.String()))
+			session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+				Content: &msg,
+			})
+		},
+		VolumeCommand: func(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+			session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			})
+			volume, err := VolumeCommandHandler(session, interaction)
+
+			if err != nil {
+				msg := common.Boldify(err.Error())
+				session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+					Content: &msg,
+				})
+				return
+			}
+
+			msg := common.Boldify(fmt.Sprintf(SetVolume, volume))
+			session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+				Content: &msg,
+			})
+		},
+		FilterCommand: func(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+			session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			})
+			filters, err := FilterCommandHandler(session, interaction)
+
+			if err != nil {
+				msg := common.Boldify(err.Error())
+				session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+					Content: &msg,
+				})
+				return
+			}
+
+			msg := common.Boldify(NoActiveFilters)
+			if len(filters) > 0 {
+				msg = common.Boldify(fmt.Sprintf(ActiveFilters, strings.Join(filters, ", ")))
+			}
+			session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+				Content: &msg,
+			})
+		},
+		CrossfadeCommand: func(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+			session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			})
+			crossfade, err := CrossfadeCommandHandler(session, interaction)
+
+			if err != nil {
+				msg := common.Boldify(err.Error())
+				session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+					Content: &msg,
+				})
+				return
+			}
+
+			msg := common.Boldify(DisableCrossfade)
+			if crossfade > 0 {
+				msg = common.Boldify(fmt.Sprintf(SetCrossfade, crossfade.String()))
+			}
+			session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+				Content: &msg,
+			})
+		},
+		SfxCommand: func(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+			session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			})
+			name, err := SfxCommandHandler(session, interaction)
+
+			if err != nil {
+				msg := common.Boldify(err.Error())
+				session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+					Content: &msg,
+				})
+				return
+			}
+
+			msg := common.Boldify(fmt.Sprintf(PlaySfx, name))
+			session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+				Content: &msg,
+			})
+		},
+		NormalizeCommand: func(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+			session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			})
+			normalize, targetLoudness, err := NormalizeCommandHandler(session, interaction)
+
+			if err != nil {
+				msg := common.Boldify(err.Error())
+				session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+					Content: &msg,
+				})
+				return
+			}
+
+			msg := common.Boldify(DisableNormalization)
+			if normalize {
+				msg = common.Boldify(fmt.Sprintf(EnableNormalization, targetLoudness))
+			}
+			session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+				Content: &msg,
+			})
+		},
+		EqCommand: func(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+			session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			})
+			gains, curve, err := EqCommandHandler(session, interaction)
+
+			if err != nil {
+				msg := common.Boldify(err.Error())
+				session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+					Content: &msg,
+				})
+				return
+			}
+
+			msg := generateEqualizerMessage(gains, curve)
+			session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+				Content: &msg,
+			})
+		},
+		SpeedCommand: func(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+			session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			})
+			speed, err := SpeedCommandHandler(session, interaction)
+
+			if err != nil {
+				msg := common.Boldify(err.Error())
+				session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+					Content: &msg,
+				})
+				return
+			}
+
+			msg := common.Boldify(fmt.Sprintf(SetSpeed, speed))
+			session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+				Content: &msg,
+			})
+		},
+		PitchCommand: func(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+			session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			})
+			pitch, err := PitchCommandHandler(session, interaction)
+
+			if err != nil {
+				msg := common.Boldify(err.Error())
+				session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+					Content: &msg,
+				})
+				return
+			}
+
+			msg := common.Boldify(fmt.Sprintf(SetPitch, pitch))
+			session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+				Content: &msg,
+			})
+		},
+		TrimSilenceCommand: func(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+			session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			})
+			trimSilence, err := TrimSilenceCommandHandler(session, interaction)
+
+			if err != nil {
+				msg := common.Boldify(err.Error())
+				session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+					Content: &msg,
+				})
+				return
+			}
+
+			msg := common.Boldify(DisableTrimSilence)
+			if trimSilence {
+				msg = common.Boldify(EnableTrimSilence)
+			}
+			session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+				Content: &msg,
+			})
+		},
+		NowPlayingCommand: func(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+			session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			})
+			song, elapsed, speed, paused, err := NowPlayingCommandHandler(session, interaction)
+
+			if err != nil {
+				msg := common.Boldify(err.Error())
+				session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+					Content: &msg,
+				})
+				return
+			}
+
+			msg := generateNowPlayingMessage(song, elapsed, speed, paused)
+			session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+				Content: &msg,
+			})
+		},
+		AudioQualityCommand: func(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+			session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			})
+			settings, err := AudioQualityCommandHandler(session, interaction)
+
+			if err != nil {
+				msg := common.Boldify(err.Error())
+				session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+					Content: &msg,
+				})
+				return
+			}
+
+			fec := "off"
+			if settings.Fec {
+				fec = "on"
+			}
+			msg := common.Boldify(fmt.Sprintf(AudioQuality, settings.BitrateKbps, fec, settings.PacketLossPercent))
+			session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+				Content: &msg,
+			})
+		},
+		CacheStatsCommand: func(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+			session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			})
+			stats := CacheStatsCommandHandler(session, interaction)
+
+			msg := generateCacheStatsMessage(stats)
+			session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+				Content: &msg,
+			})
+		},
+	}
+	// autocomplete handlers for command options
+	autocompleteHandlers = map[string]func(session *discordgo.Session, interaction *discordgo.InteractionCreate){
+		FilterCommand: func(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+			err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+				Data: &discordgo.InteractionResponseData{
+					Choices: FilterAutocompleteHandler(session, interaction),
+				},
+			})
+			if err != nil {
+				log.Printf("Failed to send autocomplete response for filter. Got error: %s", err.Error())
+			}
+		},
+		SfxCommand: func(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+			err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+				Data: &discordgo.InteractionResponseData{
+					Choices: SfxAutocompleteHandler(session, interaction),
+				},
+			})
+			if err != nil {
+				log.Printf("Failed to send autocomplete response for sfx. Got error: %s", err.Error())
+			}
+		},
 	}
 	componentHandlers = map[string]func(session *discordgo.Session, interaction *discordgo.InteractionCreate){
 		SearchComponent: func(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
@@ -351,3 +961,27 @@ var (
 	}
 	RegisteredCommands = make([]*discordgo.ApplicationCommand, len(commands))
 )
+
+// choices for saved equalizer curves
+func eqCurveChoices() []*discordgo.ApplicationCommandOptionChoice {
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0)
+	for _, name := range eqCurveNames() {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  name,
+			Value: name,
+		})
+	}
+	return choices
+}
+
+// choices for equalizer band frequencies
+func eqBandChoices() []*discordgo.ApplicationCommandOptionChoice {
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0)
+	for _, frequency := range eqFrequencies {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  formatFrequency(frequency),
+			Value: frequency,
+		})
+	}
+	return choices
+}
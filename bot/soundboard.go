@@ -0,0 +1,223 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package bot
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"layeh.com/gopus"
+)
+
+// modes to play a sound effect
+const (
+	// mix sound effect with current song
+	SfxModeOverlay = "overlay"
+	// hold current song while sound effect is played
+	SfxModeInterrupt = "interrupt"
+)
+
+// extensions of sound effect files. Ogg files need to be Ogg/Opus
+var sfxExtensions = []string{".dca", opusExtension, oggExtension}
+
+var sfxDirectory string
+
+// opus frames of a sound effect file
+type soundClip interface {
+	ReadFrame() ([]byte, error)
+	Channels() int
+}
+
+// set directory from where sound effects are played
+func InitSoundEffects(directory string) error {
+	log.Printf("Initializing sound effects from '%s'", directory)
+	info, err := os.Stat(directory)
+	if err != nil {
+		log.Printf("Failed to open sound effects directory. Got error: [%s]", err.Error())
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("'%s' is not a directory", directory)
+	}
+	sfxDirectory = directory
+	log.Printf("Found sound effects: %v", listSoundEffects())
+	return nil
+}
+
+// names of sound effects in sound effects directory
+func listSoundEffects() []string {
+	names := make([]string, 0)
+	if sfxDirectory == "" {
+		return names
+	}
+	entries, err := os.ReadDir(sfxDirectory)
+	if err != nil {
+		log.Printf("Failed to list sound effects directory. Got error: [%s]", err.Error())
+		return names
+	}
+	found := make(map[string]bool)
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		name := strings.TrimSuffix(entry.Name(), ext)
+		if entry.IsDir() || !isSfxExtension(ext) || found[name] {
+			continue
+		}
+		found[name] = true
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func isSfxExtension(ext string) bool {
+	for _, sfxExtension := range sfxExtensions {
+		if ext == sfxExtension {
+			return true
+		}
+	}
+	return false
+}
+
+// path of a sound effect file. Extensions are tried in order if files with
+// same name exist
+func soundEffectPath(name string) (string, bool) {
+	for _, ext := range sfxExtensions {
+		path := filepath.Join(sfxDirectory, name+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// open a DCA or Ogg/Opus sound effect
+func openSoundClip(path string, file io.Reader) (soundClip, error) {
+	if filepath.Ext(path) == opusExtension || filepath.Ext(path) == oggExtension {
+		return NewOggOpusReader(file)
+	}
+	return NewDCAReader(file)
+}
+
+// play a sound effect from sound effects directory in the given mode. Volume
+// is in percent of the queue volume
+func (botInstance *BotInstance) playSoundEffect(name, mode string, volume int) error {
+	logCtx := fmt.Sprintf("[%s | %s]", botInstance.GuildId, botInstance.VoiceChannelId)
+	found := false
+	for _, sfx := range listSoundEffects() {
+		found = found || sfx == name
+	}
+	path, ok := soundEffectPath(name)
+	if !found || !ok {
+		return fmt.Errorf("Couldn't find sound effect '%s'", name)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("%s Failed to open sound effect '%s'. Got error: %s", logCtx, name, err.Error())
+		return fmt.Errorf("Couldn't play sound effect '%s'", name)
+	}
+	clip, err := openSoundClip(path, file)
+	if err != nil {
+		file.Close()
+		log.Printf("%s Failed to read sound effect '%s'. Got error: %s", logCtx, name, err.Error())
+		return fmt.Errorf("Couldn't play sound effect '%s'", name)
+	}
+
+	log.Printf("%s Playing sound effect '%s' in %s mode at %d%% volume", logCtx, name, mode, volume)
+	gain := float64(volume) / 100 * float64(botInstance.AudioSettings.getVolume()) / 100
+	if mode == SfxModeInterrupt {
+		go botInstance.interruptWithSoundEffect(logCtx, file, clip, gain)
+	} else {
+		go botInstance.overlaySoundEffect(logCtx, file, clip, gain)
+	}
+	return nil
+}
+
+// decode sound effect and mix it with current song
+func (botInstance *BotInstance) overlaySoundEffect(logCtx string, file *os.File, clip soundClip, gain float64) {
+	defer file.Close()
+	// current song needs to be decoded to mix sound effect with it
+	botInstance.Queue.mtx.Lock()
+	if botInstance.Queue.nowPlaying != nil {
+		botInstance.Queue.nowPlaying.streamSession.requirePCM()
+	}
+	botInstance.Queue.mtx.Unlock()
+
+	playSoundClip(logCtx, clip, botInstance.Mixer.addSource(PriorityEffect, gain, 0))
+}
+
+// play sound effect through the mixer as an interrupting source. Current song
+// and other sources are held by the mixer till the sound effect ends
+func (botInstance *BotInstance) interruptWithSoundEffect(logCtx string, file *os.File, clip soundClip, gain float64) {
+	defer file.Close()
+	// current song and songs started meanwhile need to be decoded to be held
+	// by the mixer
+	botInstance.Queue.mtx.Lock()
+	botInstance.Queue.interruptions++
+	if botInstance.Queue.nowPlaying != nil {
+		botInstance.Queue.nowPlaying.streamSession.requirePCM()
+	}
+	botInstance.Queue.mtx.Unlock()
+	defer func() {
+		botInstance.Queue.mtx.Lock()
+		botInstance.Queue.interruptions--
+		botInstance.Queue.mtx.Unlock()
+	}()
+
+	playSoundClip(logCtx, clip, botInstance.Mixer.addSource(PriorityInterrupt, gain, 0))
+}
+
+// decode opus frames of a sound effect and send them to a mixer source. The
+// source is closed once the sound effect ends
+func playSoundClip(logCtx string, clip soundClip, source *mixerSource) {
+	defer close(source.frames)
+	channels := clip.Channels()
+	decoder, err := gopus.NewDecoder(framerate, channels)
+	if err != nil {
+		log.Printf("%s Failed to create opus decoder. Got error: %s", logCtx, err.Error())
+		return
+	}
+
+	for {
+		opus, err := clip.ReadFrame()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Printf("%s Failed to read sound effect frame. Got error: %s", logCtx, err.Error())
+			return
+		}
+		pcm, err := decoder.Decode(opus, framesize, false)
+		if err != nil {
+			log.Printf("%s Failed to decode sound effect frame. Got error: %s", logCtx, err.Error())
+			return
+		}
+		select {
+		case source.frames <- toMixerFrame(pcm, channels):
+		case <-source.removed:
+			return
+		}
+	}
+}
+
+// convert decoded PCM to a stereo frame of the size used by the mixer
+func toMixerFrame(pcm []int16, channels int) []int16 {
+	frame := make([]int16, framesize*numChannels)
+	if channels == 1 {
+		for idx := 0; idx < len(pcm) && idx < framesize; idx++ {
+			frame[2*idx] = pcm[idx]
+			frame[2*idx+1] = pcm[idx]
+		}
+		return frame
+	}
+	copy(frame, pcm)
+	return frame
+}
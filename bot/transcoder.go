@@ -0,0 +1,125 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// decodes a song to raw 48KHz stereo s16le PCM
+type Transcoder interface {
+	// open song at given url from offset with an optional ffmpeg filter chain.
+	// Closing the reader interrupts any pending read. Transcoders which can't
+	// apply filters return errFiltersNotSupported
+	Open(songUrl string, offset time.Duration, filter string) (io.ReadCloser, error)
+}
+
+var errFiltersNotSupported = errors.New("Transcoder doesn't support filters")
+
+// transcoder used for songs which aren't local PCM files. Can be replaced to
+// run the playback pipeline without ffmpeg
+var defaultTranscoder Transcoder = FFmpegTranscoder{}
+
+// get transcoder for a song. Local WAV and raw PCM files are decoded without
+// ffmpeg
+func transcoderFor(songUrl string) Transcoder {
+	if isPCMFile(songUrl) {
+		return PCMFileTranscoder{}
+	}
+	return defaultTranscoder
+}
+
+// transcodes any source supported by ffmpeg
+type FFmpegTranscoder struct{}
+
+func (FFmpegTranscoder) Open(songUrl string, offset time.Duration, filter string) (io.ReadCloser, error) {
+	run := exec.Command("ffmpeg", ffmpegArgs(songUrl, offset, filter)...)
+	ffmpegOut, err := run.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create stdout pipe for ffmpeg. Error: %s", err.Error())
+	}
+	err = run.Start()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to start ffmpeg command. Error: %s", err.Error())
+	}
+	return &ffmpegStream{
+		run:    run,
+		stdout: ffmpegOut,
+	}, nil
+}
+
+// PCM output of a running ffmpeg process
+type ffmpegStream struct {
+	run       *exec.Cmd
+	stdout    io.ReadCloser
+	closeOnce sync.Once
+}
+
+func (stream *ffmpegStream) Read(buf []byte) (int, error) {
+	return stream.stdout.Read(buf)
+}
+
+// kill ffmpeg process and wait for it to exit
+func (stream *ffmpegStream) Close() error {
+	stream.closeOnce.Do(func() {
+		stream.run.Process.Kill()
+		stream.run.Wait()
+	})
+	return nil
+}
+
+// build ffmpeg arguments to decode the song from given offset to raw PCM with
+// optional filter chain
+func ffmpegArgs(songUrl string, offset time.Duration, filter string) []string {
+	args := []string{
+		"-reconnect", "1",
+		"-reconnect_at_eof", "1",
+		"-reconnect_streamed", "1",
+		"-reconnect_delay_max", "2",
+	}
+	if offset > 0 {
+		args = append(args, "-ss", fmt.Sprintf("%.3f", offset.Seconds()))
+	}
+	args = append(args,
+		"-i", songUrl,
+		"-vn",
+	)
+	if filter != "" {
+		args = append(args, "-af", filter)
+	}
+	// ffmpeg outputs raw PCM, encoding is done by the mixer
+	return append(args,
+		"-f", "s16le",
+		"-ar", strconv.Itoa(int(framerate)),
+		"-ac", strconv.Itoa(int(numChannels)),
+		"pipe:1",
+	)
+}
+
+// check if url is a path or file url of a local file
+func isLocalFile(songUrl string) bool {
+	return !strings.Contains(songUrl, "://") || strings.HasPrefix(songUrl, "file://")
+}
+
+// check if url is a local WAV or raw PCM file
+func isPCMFile(songUrl string) bool {
+	if !isLocalFile(songUrl) {
+		return false
+	}
+	switch strings.ToLower(filepath.Ext(songUrl)) {
+	case wavExtension, pcmExtension, rawExtension:
+		return true
+	}
+	return false
+}
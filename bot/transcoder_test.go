@@ -0,0 +1,128 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package bot
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// opening of a song by the fake transcoder
+type fakeOpen struct {
+	url    string
+	offset time.Duration
+}
+
+// transcoder producing PCM of songs without ffmpeg. Every sample of a frame is
+// its index in the song plus one, so tests can tell which frames were played
+type fakeTranscoder struct {
+	mtx sync.Mutex
+	// number of frames of each song url
+	songFrames map[string]int
+	opens      []fakeOpen
+}
+
+func newFakeTranscoder(songFrames map[string]int) *fakeTranscoder {
+	return &fakeTranscoder{songFrames: songFrames}
+}
+
+func (transcoder *fakeTranscoder) Open(songUrl string, offset time.Duration, filter string) (io.ReadCloser, error) {
+	transcoder.mtx.Lock()
+	defer transcoder.mtx.Unlock()
+	transcoder.opens = append(transcoder.opens, fakeOpen{url: songUrl, offset: offset})
+	return &fakePCMStream{
+		frame:  durationToFrames(offset),
+		frames: transcoder.songFrames[songUrl],
+	}, nil
+}
+
+// songs opened so far in order
+func (transcoder *fakeTranscoder) getOpens() []fakeOpen {
+	transcoder.mtx.Lock()
+	defer transcoder.mtx.Unlock()
+	return append([]fakeOpen(nil), transcoder.opens...)
+}
+
+// PCM of a song opened by the fake transcoder
+type fakePCMStream struct {
+	mtx    sync.Mutex
+	frame  int
+	frames int
+	buf    []byte
+	closed bool
+}
+
+func (stream *fakePCMStream) Read(buf []byte) (int, error) {
+	stream.mtx.Lock()
+	defer stream.mtx.Unlock()
+	if stream.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if len(stream.buf) == 0 {
+		if stream.frame >= stream.frames {
+			return 0, io.EOF
+		}
+		stream.buf = fakeFrameBytes(stream.frame)
+		stream.frame++
+	}
+	n := copy(buf, stream.buf)
+	stream.buf = stream.buf[n:]
+	return n, nil
+}
+
+func (stream *fakePCMStream) Close() error {
+	stream.mtx.Lock()
+	defer stream.mtx.Unlock()
+	stream.closed = true
+	return nil
+}
+
+// s16le PCM of a frame of the fake transcoder
+func fakeFrameBytes(frame int) []byte {
+	data := make([]byte, framesize*numChannels*2)
+	for idx := 0; idx < framesize*numChannels; idx++ {
+		binary.LittleEndian.PutUint16(data[2*idx:], uint16(frame+1))
+	}
+	return data
+}
+
+// replace default transcoder for a test
+func useTranscoder(t *testing.T, transcoder Transcoder) {
+	previous := defaultTranscoder
+	defaultTranscoder = transcoder
+	t.Cleanup(func() {
+		defaultTranscoder = previous
+	})
+}
+
+func TestTranscoderFor(t *testing.T) {
+	fake := newFakeTranscoder(nil)
+	useTranscoder(t, fake)
+
+	tests := []struct {
+		url     string
+		pcmFile bool
+	}{
+		{url: "/music/song.wav", pcmFile: true},
+		{url: "file:///music/song.PCM", pcmFile: true},
+		{url: "song.raw", pcmFile: true},
+		{url: "/music/song.mp3"},
+		{url: "https://example.com/song.wav"},
+	}
+	for _, test := range tests {
+		transcoder := transcoderFor(test.url)
+		_, isPCMFile := transcoder.(PCMFileTranscoder)
+		if isPCMFile != test.pcmFile {
+			t.Errorf("transcoderFor(%q) = %T", test.url, transcoder)
+		}
+		if !test.pcmFile && transcoder != Transcoder(fake) {
+			t.Errorf("transcoderFor(%q) didn't use default transcoder", test.url)
+		}
+	}
+}
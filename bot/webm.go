@@ -0,0 +1,397 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package bot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// EBML element ids used by WebM
+const (
+	ebmlIdSegment           = 0x18538067
+	ebmlIdInfo              = 0x1549A966
+	ebmlIdTimecodeScale     = 0x2AD7B1
+	ebmlIdTracks            = 0x1654AE6B
+	ebmlIdTrackEntry        = 0xAE
+	ebmlIdTrackNumber       = 0xD7
+	ebmlIdTrackType         = 0x83
+	ebmlIdCodecId           = 0x86
+	ebmlIdAudio             = 0xE1
+	ebmlIdSamplingFrequency = 0xB5
+	ebmlIdChannels          = 0x9F
+	ebmlIdCluster           = 0x1F43B675
+	ebmlIdTimecode          = 0xE7
+	ebmlIdSimpleBlock       = 0xA3
+	ebmlIdBlockGroup        = 0xA0
+	ebmlIdBlock             = 0xA1
+)
+
+const (
+	// size of master elements streamed without a known size
+	ebmlUnknownSize = -1
+	webmAudioTrack  = 2
+	webmCodecOpus   = "A_OPUS"
+	// duration of each opus packet sent by the bot
+	opusPacketDuration = 20 * time.Millisecond
+	// max size of elements read into memory. Sizes come from the stream, so
+	// bigger elements are treated as a corrupt stream
+	webmMaxElementSize = 4 << 20
+	webmMaxBlockSize   = 32 << 20
+)
+
+var errWebMNotOpus = errors.New("WebM stream has no 48KHz opus audio track")
+
+// demuxes opus packets of the first audio track from a WebM stream
+type WebMReader struct {
+	reader *bufio.Reader
+
+	// nanoseconds per timecode unit
+	timecodeScale   int64
+	clusterTimecode int64
+	track           webmTrack
+	// frames of a laced block which are not read yet
+	pending     [][]byte
+	pendingTime time.Duration
+}
+
+type webmTrack struct {
+	number     uint64
+	trackType  uint64
+	codecId    string
+	sampleRate float64
+	channels   uint64
+}
+
+// read WebM headers till the first cluster. Returns errWebMNotOpus if the
+// stream has no opus audio track at 48KHz
+func NewWebMReader(reader io.Reader) (*WebMReader, error) {
+	webm := &WebMReader{
+		reader:        bufio.NewReader(reader),
+		timecodeScale: 1000000,
+	}
+	for {
+		id, size, err := webm.readElementHeader()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read WebM header. Error: %s", err.Error())
+		}
+		switch id {
+		case ebmlIdSegment, ebmlIdInfo, ebmlIdTracks:
+			// read children of master elements
+			continue
+		case ebmlIdTimecodeScale:
+			data, err := webm.readElementData(size, webmMaxElementSize)
+			if err != nil {
+				return nil, err
+			}
+			webm.timecodeScale = int64(readEbmlUint(data))
+		case ebmlIdTrackEntry:
+			data, err := webm.readElementData(size, webmMaxElementSize)
+			if err != nil {
+				return nil, err
+			}
+			track := parseWebMTrack(data)
+			if webm.track.number == 0 && track.trackType == webmAudioTrack {
+				webm.track = track
+			}
+		case ebmlIdCluster:
+			// headers are done, packets start from here
+			if webm.track.codecId != webmCodecOpus || webm.track.sampleRate != float64(framerate) {
+				return nil, errWebMNotOpus
+			}
+			return webm, nil
+		default:
+			err = webm.skipElement(size)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// read next opus packet and its timestamp in the stream. Returns io.EOF at the
+// end of the stream
+func (webm *WebMReader) ReadPacket() ([]byte, time.Duration, error) {
+	for len(webm.pending) == 0 {
+		id, size, err := webm.readElementHeader()
+		if err != nil {
+			return nil, 0, err
+		}
+		switch id {
+		case ebmlIdSegment, ebmlIdCluster, ebmlIdBlockGroup:
+			continue
+		case ebmlIdTimecode:
+			data, err := webm.readElementData(size, webmMaxElementSize)
+			if err != nil {
+				return nil, 0, err
+			}
+			webm.clusterTimecode = int64(readEbmlUint(data))
+		case ebmlIdSimpleBlock, ebmlIdBlock:
+			data, err := webm.readElementData(size, webmMaxBlockSize)
+			if err != nil {
+				return nil, 0, err
+			}
+			err = webm.parseBlock(data)
+			if err != nil {
+				return nil, 0, err
+			}
+		default:
+			err = webm.skipElement(size)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	packet := webm.pending[0]
+	timestamp := webm.pendingTime
+	webm.pending = webm.pending[1:]
+	webm.pendingTime += opusPacketDuration
+	return packet, timestamp, nil
+}
+
+// read id and size of next element
+func (webm *WebMReader) readElementHeader() (uint64, int64, error) {
+	id, _, err := readVint(webm.reader, true)
+	if err != nil {
+		return 0, 0, err
+	}
+	size, length, err := readVint(webm.reader, false)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, 0, err
+	}
+	// all data bits set means unknown size
+	if size == 1<<(7*length)-1 {
+		return id, ebmlUnknownSize, nil
+	}
+	return id, int64(size), nil
+}
+
+// read data of an element which is at most maxSize bytes
+func (webm *WebMReader) readElementData(size, maxSize int64) ([]byte, error) {
+	if size == ebmlUnknownSize {
+		return nil, fmt.Errorf("Unknown size for WebM element with data")
+	}
+	if size > maxSize {
+		return nil, fmt.Errorf("WebM element of %d bytes is bigger than %d bytes", size, maxSize)
+	}
+	data := make([]byte, size)
+	_, err := io.ReadFull(webm.reader, data)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return data, err
+}
+
+func (webm *WebMReader) skipElement(size int64) error {
+	if size == ebmlUnknownSize {
+		return fmt.Errorf("Can't skip WebM element of unknown size")
+	}
+	_, err := webm.reader.Discard(int(size))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// parse a Block or SimpleBlock and store its frames if it belongs to the
+// audio track
+func (webm *WebMReader) parseBlock(data []byte) error {
+	reader := bytes.NewReader(data)
+	trackNumber, _, err := readVint(reader, false)
+	if err != nil {
+		return err
+	}
+	if trackNumber != webm.track.number {
+		return nil
+	}
+	var header struct {
+		Timecode int16
+		Flags    uint8
+	}
+	err = binary.Read(reader, binary.BigEndian, &header)
+	if err != nil {
+		return fmt.Errorf("Invalid WebM block header. Error: %s", err.Error())
+	}
+	timecode := webm.clusterTimecode + int64(header.Timecode)
+	webm.pendingTime = time.Duration(timecode * webm.timecodeScale)
+
+	payload := data[len(data)-reader.Len():]
+	lacing := header.Flags & 0x06
+	if lacing == 0 {
+		webm.pending = [][]byte{payload}
+		return nil
+	}
+	frames, err := unlaceBlock(lacing, payload)
+	if err != nil {
+		return err
+	}
+	webm.pending = frames
+	return nil
+}
+
+// split frames of a laced block
+func unlaceBlock(lacing uint8, payload []byte) ([][]byte, error) {
+	if len(payload) == 0 {
+		return nil, fmt.Errorf("Empty laced WebM block")
+	}
+	numFrames := int(payload[0]) + 1
+	reader := bytes.NewReader(payload[1:])
+	sizes := make([]int, numFrames)
+	switch lacing {
+	case 0x02:
+		// xiph lacing, sizes are sums of bytes till a byte less than 255
+		for idx := 0; idx < numFrames-1; idx++ {
+			for {
+				val, err := reader.ReadByte()
+				if err != nil {
+					return nil, fmt.Errorf("Invalid xiph lacing in WebM block")
+				}
+				sizes[idx] += int(val)
+				if val < 255 {
+					break
+				}
+			}
+		}
+	case 0x06:
+		// ebml lacing, first size followed by signed differences
+		size, _, err := readVint(reader, false)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ebml lacing in WebM block")
+		}
+		sizes[0] = int(size)
+		for idx := 1; idx < numFrames-1; idx++ {
+			diff, length, err := readVint(reader, false)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid ebml lacing in WebM block")
+			}
+			bias := int64(1)<<(7*length-1) - 1
+			sizes[idx] = sizes[idx-1] + int(int64(diff)-bias)
+		}
+	case 0x04:
+		// fixed size lacing
+		for idx := range sizes {
+			sizes[idx] = reader.Len() / numFrames
+		}
+	}
+	if lacing != 0x04 {
+		known := 0
+		for _, size := range sizes[:numFrames-1] {
+			known += size
+		}
+		sizes[numFrames-1] = reader.Len() - known
+	}
+
+	rest := payload[len(payload)-reader.Len():]
+	frames := make([][]byte, 0, numFrames)
+	for _, size := range sizes {
+		if size < 0 || size > len(rest) {
+			return nil, fmt.Errorf("Invalid frame size in laced WebM block")
+		}
+		frames = append(frames, rest[:size])
+		rest = rest[size:]
+	}
+	return frames, nil
+}
+
+// parse children of a TrackEntry element
+func parseWebMTrack(data []byte) webmTrack {
+	track := webmTrack{}
+	parseEbmlChildren(data, func(id uint64, value []byte) {
+		switch id {
+		case ebmlIdTrackNumber:
+			track.number = readEbmlUint(value)
+		case ebmlIdTrackType:
+			track.trackType = readEbmlUint(value)
+		case ebmlIdCodecId:
+			track.codecId = string(bytes.TrimRight(value, "\x00"))
+		case ebmlIdAudio:
+			parseEbmlChildren(value, func(id uint64, value []byte) {
+				switch id {
+				case ebmlIdSamplingFrequency:
+					track.sampleRate = readEbmlFloat(value)
+				case ebmlIdChannels:
+					track.channels = readEbmlUint(value)
+				}
+			})
+		}
+	})
+	return track
+}
+
+// call fn for each child element in data of a master element
+func parseEbmlChildren(data []byte, fn func(id uint64, value []byte)) {
+	reader := bytes.NewReader(data)
+	for reader.Len() > 0 {
+		id, _, err := readVint(reader, true)
+		if err != nil {
+			return
+		}
+		size, _, err := readVint(reader, false)
+		if err != nil || size > uint64(reader.Len()) {
+			return
+		}
+		offset := len(data) - reader.Len()
+		fn(id, data[offset:offset+int(size)])
+		reader.Seek(int64(size), io.SeekCurrent)
+	}
+}
+
+// read a variable length integer. Element ids keep the length marker bit
+func readVint(reader io.ByteReader, keepMarker bool) (uint64, int, error) {
+	first, err := reader.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	length := 1
+	for mask := byte(0x80); mask != 0 && first&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 {
+		return 0, 0, fmt.Errorf("Invalid EBML variable length integer")
+	}
+	value := uint64(first)
+	if !keepMarker {
+		value &= uint64(0xFF >> length)
+	}
+	for idx := 1; idx < length; idx++ {
+		next, err := reader.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, 0, err
+		}
+		value = value<<8 | uint64(next)
+	}
+	return value, length, nil
+}
+
+func readEbmlUint(data []byte) uint64 {
+	var value uint64
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+func readEbmlFloat(data []byte) float64 {
+	switch len(data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	}
+	return 0
+}
@@ -9,14 +9,30 @@ import (
 	"time"
 )
 
-// struct for song to be streamed
+// playable stream of a song
+type StreamInfo struct {
+	Url        string
+	MimeType   string
+	SampleRate int
+}
+
+// struct for song to be streamed. Songs are queued with their metadata and
+// their stream is resolved when they are played
 type Song struct {
-	SongUrl       string
-	SongTitle     string
-	SongDuration  time.Duration
-	User          string
-	SongId        string
-	ChannelId     string
-	ChannelName   string
-	YoutubeSource bool
+	SongTitle    string
+	SongDuration time.Duration
+	User         string
+	SongId       string
+	ChannelId    string
+	ChannelName  string
+	// name of the music source the song is from
+	Source string
+	// offset in the song from where streaming starts
+	StartAt time.Duration
+	// stream of the song, nil until it is resolved by its source
+	Stream *StreamInfo
+	// album and ReplayGain track gain in dB from tags of local files
+	Album         string
+	ReplayGain    float64
+	HasReplayGain bool
 }
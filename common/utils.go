@@ -11,6 +11,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
@@ -42,3 +45,49 @@ func ShortenSongTitle(songTitle string) string {
 	}
 	return songTitle[:20] + "..."
 }
+
+// parse a timestamp like '90', '1:30', '01:02:03' or youtube style '1m30s'
+// into a duration
+func ParseTimestamp(timestamp string) (time.Duration, error) {
+	timestamp = strings.TrimSpace(timestamp)
+	if timestamp == "" {
+		return 0, fmt.Errorf("Empty timestamp")
+	}
+	// youtube style timestamps with units
+	if strings.ContainsAny(timestamp, "hms") {
+		duration, err := time.ParseDuration(timestamp)
+		if err != nil || duration < 0 {
+			return 0, fmt.Errorf("Invalid timestamp '%s'", timestamp)
+		}
+		return duration, nil
+	}
+	parts := strings.Split(timestamp, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("Invalid timestamp '%s'", timestamp)
+	}
+	var seconds int
+	for idx, part := range parts {
+		val, err := strconv.Atoi(part)
+		if err != nil || val < 0 {
+			return 0, fmt.Errorf("Invalid timestamp '%s'", timestamp)
+		}
+		// only the first field may be 60 or more, like '90' or '90:00'
+		if idx > 0 && val >= 60 {
+			return 0, fmt.Errorf("Invalid timestamp '%s'", timestamp)
+		}
+		seconds = seconds*60 + val
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// format a duration as a timestamp like '4:05' or '1:02:03'
+func FormatTimestamp(duration time.Duration) string {
+	seconds := int(duration.Round(time.Second) / time.Second)
+	if seconds < 0 {
+		seconds = 0
+	}
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
@@ -20,11 +20,24 @@ import (
 var (
 	botToken      string
 	youtubeAPIKey string
+	sfxDirectory  string
+	libraryDir    string
+	cacheFile     string
+	// audio file cache
+	audioCacheDir   string
+	audioCacheMB    int
+	audioCachePlays int
 )
 
 func init() {
 	flag.StringVar(&botToken, "bottoken", "", "Token for discord bot")
-	flag.StringVar(&youtubeAPIKey, "youtubeapikey", "", "API key for youtube APIs")
+	flag.StringVar(&youtubeAPIKey, "youtubeapikey", "", "API key for youtube APIs. Youtube website is searched without it")
+	flag.StringVar(&sfxDirectory, "sfxdir", "audios", "Directory with DCA files for sound effects")
+	flag.StringVar(&libraryDir, "librarydir", "", "Directory with MP3, FLAC, Ogg and Opus files for local library")
+	flag.StringVar(&audioCacheDir, "audiocachedir", "", "Directory to cache audio of songs played often in. Empty to disable")
+	flag.IntVar(&audioCacheMB, "audiocachemb", 2048, "Max size of audio file cache in MB")
+	flag.IntVar(&audioCachePlays, "audiocacheplays", 3, "Plays of a song before its audio is cached")
+	flag.StringVar(&cacheFile, "cachefile", "metadata-cache.json", "File to cache youtube search results and video metadata in. Empty to cache in memory only")
 }
 
 func main() {
@@ -34,15 +47,51 @@ func main() {
 	if botToken == "" {
 		log.Panicf("Please enter bot token")
 	}
-	if youtubeAPIKey == "" {
-		log.Panicf("Please enter youtube api key")
-	}
 	// init youtube service client
 	err := musicmanager.InitYoutubeClient(youtubeAPIKey)
 	if err != nil {
 		log.Panicf("Failed to init youtube client. Got error: [%s]", err.Error())
 	}
 
+	// cache is kept in memory only if its file can't be used
+	if cacheFile != "" {
+		err = musicmanager.InitCache(cacheFile)
+		if err != nil {
+			log.Printf("Metadata cache won't be saved. Got error: [%s]", err.Error())
+		}
+		// save changes made since last periodic flush
+		defer musicmanager.Cache.Flush()
+	}
+
+	// audio file cache is optional, songs are streamed without it
+	if audioCacheDir != "" {
+		err = musicmanager.InitAudioFileCache(audioCacheDir, int64(audioCacheMB)*1024*1024, audioCachePlays)
+		if err != nil {
+			log.Printf("Audio file cache disabled. Got error: [%s]", err.Error())
+		}
+	}
+
+	// sound effects are optional, bot works without them
+	err = bot.InitSoundEffects(sfxDirectory)
+	if err != nil {
+		log.Printf("Sound effects disabled. Got error: [%s]", err.Error())
+	}
+
+	// local library is optional, songs are searched on youtube without it
+	if libraryDir != "" {
+		err = musicmanager.InitLibrary(libraryDir)
+		if err != nil {
+			log.Printf("Local library disabled. Got error: [%s]", err.Error())
+		}
+	}
+
+	// register music sources. Library tracks are preferred over youtube in
+	// search results
+	if musicmanager.Library != nil {
+		musicmanager.RegisterProvider(musicmanager.Library)
+	}
+	musicmanager.RegisterProvider(musicmanager.YtServiceClient)
+
 	// start session for bot
 	err = bot.StartBot(botToken)
 	if err != nil {
@@ -0,0 +1,291 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package musicmanager
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Ar5h71/r4-music-bot/common"
+)
+
+const (
+	// index of cached files and play counts in cache directory
+	audioCacheIndexFile = "index.json"
+	// suffix of files being downloaded
+	audioCacheTmpSuffix = ".tmp"
+)
+
+// audio file of a song in the cache
+type cachedAudioFile struct {
+	File       string    `json:"file"`
+	MimeType   string    `json:"mimeType"`
+	SampleRate int       `json:"sampleRate"`
+	Size       int64     `json:"size"`
+	LastUsed   time.Time `json:"lastUsed"`
+}
+
+// format of the index file
+type audioCacheIndex struct {
+	Files map[string]*cachedAudioFile `json:"files"`
+	Plays map[string]int              `json:"plays"`
+}
+
+// downloads streams of songs played often to a local directory, so that
+// later plays read the file instead of the remote stream. Least recently
+// used files are evicted to keep the directory below its size cap
+type AudioFileCache struct {
+	mtx      sync.Mutex
+	dir      string
+	maxBytes int64
+	// plays needed before a song is downloaded
+	minPlays int
+	files    map[string]*cachedAudioFile
+	plays    map[string]int
+	// songs being downloaded
+	downloading map[string]bool
+	// serializes writes of the index file
+	saveMtx sync.Mutex
+}
+
+// audio file cache, nil if no cache directory is configured
+var AudioFiles *AudioFileCache
+
+// load audio file cache from a directory. Files missing from the directory
+// are dropped from its index
+func InitAudioFileCache(dir string, maxBytes int64, minPlays int) error {
+	log.Printf("Initializing audio file cache in '%s'...", dir)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		log.Printf("Failed to create audio file cache directory. Got error: [%s]", err.Error())
+		return err
+	}
+	index := audioCacheIndex{}
+	data, err := os.ReadFile(filepath.Join(dir, audioCacheIndexFile))
+	if err == nil {
+		err = json.Unmarshal(data, &index)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Failed to read audio file cache index. Got error: [%s]", err.Error())
+		return err
+	}
+
+	cache := &AudioFileCache{
+		dir:         dir,
+		maxBytes:    maxBytes,
+		minPlays:    minPlays,
+		files:       make(map[string]*cachedAudioFile),
+		plays:       make(map[string]int),
+		downloading: make(map[string]bool),
+	}
+	for key, file := range index.Files {
+		if _, err := os.Stat(filepath.Join(dir, file.File)); err == nil {
+			cache.files[key] = file
+		}
+	}
+	for key, plays := range index.Plays {
+		cache.plays[key] = plays
+	}
+	// downloads interrupted by a restart
+	tmpFiles, _ := filepath.Glob(filepath.Join(dir, "*"+audioCacheTmpSuffix))
+	for _, tmpFile := range tmpFiles {
+		os.Remove(tmpFile)
+	}
+	cache.mtx.Lock()
+	cache.evict()
+	cache.mtx.Unlock()
+	AudioFiles = cache
+	log.Printf("Found %d cached audio files", len(cache.files))
+	return nil
+}
+
+// key of a song in the cache
+func audioCacheKey(song *common.Song) string {
+	return song.Source + ":" + song.SongId
+}
+
+// get stream of the cached file of a song, if it is cached
+func (cache *AudioFileCache) stream(song *common.Song) (*common.StreamInfo, bool) {
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+	file, ok := cache.files[audioCacheKey(song)]
+	if !ok {
+		return nil, false
+	}
+	return &common.StreamInfo{
+		Url:        filepath.Join(cache.dir, file.File),
+		MimeType:   file.MimeType,
+		SampleRate: file.SampleRate,
+	}, true
+}
+
+// count a play of a song. Song is downloaded in background once it has been
+// played often enough. Songs from local files and live streams are not cached
+func (cache *AudioFileCache) RecordPlay(song *common.Song) {
+	if song.Source == LibrarySourceName || song.SongDuration == 0 {
+		return
+	}
+	key := audioCacheKey(song)
+	cache.mtx.Lock()
+	cache.plays[key]++
+	plays := cache.plays[key]
+	file, cached := cache.files[key]
+	if cached {
+		file.LastUsed = time.Now()
+	}
+	download := !cached && !cache.downloading[key] && plays >= cache.minPlays
+	if download {
+		cache.downloading[key] = true
+	}
+	cache.mtx.Unlock()
+	cache.save()
+
+	if download {
+		go cache.download(song, key)
+	}
+}
+
+// download stream of a song to the cache directory
+func (cache *AudioFileCache) download(song *common.Song, key string) {
+	logCtx := fmt.Sprintf("[%s(%s)]", song.SongTitle, song.SongId)
+	defer func() {
+		cache.mtx.Lock()
+		delete(cache.downloading, key)
+		cache.mtx.Unlock()
+	}()
+
+	provider, ok := GetProvider(song.Source)
+	if !ok {
+		return
+	}
+	stream, err := provider.StreamURL(song)
+	if err != nil {
+		log.Printf("%s Failed to get stream to cache. Got error: %s", logCtx, err.Error())
+		return
+	}
+	if !strings.Contains(stream.Url, "://") {
+		return
+	}
+	log.Printf("%s Downloading song to audio file cache", logCtx)
+	fileName := audioCacheFileName(key, stream.MimeType)
+	tmpPath := filepath.Join(cache.dir, fileName+audioCacheTmpSuffix)
+	size, err := downloadStream(stream.Url, tmpPath, cache.maxBytes)
+	if err != nil {
+		os.Remove(tmpPath)
+		log.Printf("%s Failed to download song to audio file cache. Got error: %s", logCtx, err.Error())
+		return
+	}
+	err = os.Rename(tmpPath, filepath.Join(cache.dir, fileName))
+	if err != nil {
+		os.Remove(tmpPath)
+		log.Printf("%s Failed to add song to audio file cache. Got error: %s", logCtx, err.Error())
+		return
+	}
+
+	cache.mtx.Lock()
+	cache.files[key] = &cachedAudioFile{
+		File:       fileName,
+		MimeType:   stream.MimeType,
+		SampleRate: stream.SampleRate,
+		Size:       size,
+		LastUsed:   time.Now(),
+	}
+	cache.evict()
+	cache.mtx.Unlock()
+	cache.save()
+	log.Printf("%s Cached %d bytes of audio", logCtx, size)
+}
+
+// name of the cached file of a song. Keys are hashed as song ids might not be
+// valid file names
+func audioCacheFileName(key, mimeType string) string {
+	hash := sha1.Sum([]byte(key))
+	ext := ".audio"
+	switch {
+	case strings.HasPrefix(mimeType, "audio/webm"):
+		ext = ".webm"
+	case strings.HasPrefix(mimeType, "audio/mp4"):
+		ext = ".m4a"
+	}
+	return hex.EncodeToString(hash[:]) + ext
+}
+
+// download a stream to a file. Fails if the stream is bigger than maxBytes
+func downloadStream(streamUrl, path string, maxBytes int64) (int64, error) {
+	stream := OpenStream(streamUrl)
+	defer stream.Close()
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	size, err := io.Copy(file, io.LimitReader(stream, maxBytes+1))
+	if err != nil {
+		return 0, err
+	}
+	if size > maxBytes {
+		return 0, fmt.Errorf("Stream is bigger than the cache")
+	}
+	return size, file.Sync()
+}
+
+// remove least recently used files until the cache fits its size cap. Must be
+// called with mutex held
+func (cache *AudioFileCache) evict() {
+	var total int64
+	for _, file := range cache.files {
+		total += file.Size
+	}
+	for total > cache.maxBytes && len(cache.files) > 0 {
+		oldestKey := ""
+		for key, file := range cache.files {
+			if oldestKey == "" || file.LastUsed.Before(cache.files[oldestKey].LastUsed) {
+				oldestKey = key
+			}
+		}
+		oldest := cache.files[oldestKey]
+		// sessions reading the file keep it open until they are done
+		err := os.Remove(filepath.Join(cache.dir, oldest.File))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Failed to remove '%s' from audio file cache. Got error: %s", oldest.File, err.Error())
+		}
+		total -= oldest.Size
+		delete(cache.files, oldestKey)
+	}
+}
+
+// write index of the cache to its directory
+func (cache *AudioFileCache) save() {
+	cache.saveMtx.Lock()
+	defer cache.saveMtx.Unlock()
+	cache.mtx.Lock()
+	data, err := json.Marshal(audioCacheIndex{Files: cache.files, Plays: cache.plays})
+	cache.mtx.Unlock()
+	if err != nil {
+		log.Printf("Failed to encode audio file cache index. Got error: [%s]", err.Error())
+		return
+	}
+	path := filepath.Join(cache.dir, audioCacheIndexFile)
+	tmpPath := path + audioCacheTmpSuffix
+	err = os.WriteFile(tmpPath, data, 0644)
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		log.Printf("Failed to save audio file cache index. Got error: [%s]", err.Error())
+	}
+}
@@ -0,0 +1,265 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package musicmanager
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// search results change slowly, video metadata hardly ever
+	searchCacheTTL = 24 * time.Hour
+	videoCacheTTL  = 7 * 24 * time.Hour
+	// max entries of each kind. Entries expiring first are evicted
+	maxCacheEntries = 10000
+	// changes are written to the cache file at most this often
+	cacheFlushInterval = 30 * time.Second
+)
+
+// video ids found for a search query
+type cachedSearch struct {
+	VideoIds []string `json:"videoIds"`
+	// set if the source had no more results than the ids stored
+	Complete bool      `json:"complete"`
+	Expires  time.Time `json:"expires"`
+}
+
+// metadata of a video
+type cachedVideo struct {
+	Title       string        `json:"title"`
+	ChannelId   string        `json:"channelId"`
+	ChannelName string        `json:"channelName"`
+	Duration    time.Duration `json:"duration"`
+	Expires     time.Time     `json:"expires"`
+}
+
+// hits and misses of the cache since the bot started
+type CacheStats struct {
+	SearchEntries int
+	SearchHits    int
+	SearchMisses  int
+	VideoEntries  int
+	VideoHits     int
+	VideoMisses   int
+}
+
+// cache of youtube search results and video metadata to save API quota.
+// Entries are saved to a file if one is configured
+type MetadataCache struct {
+	mtx      sync.Mutex
+	path     string
+	searches map[string]*cachedSearch
+	videos   map[string]*cachedVideo
+	stats    CacheStats
+	// set when cache has changes which aren't saved yet
+	dirty bool
+	// serializes writes of the cache file
+	saveMtx sync.Mutex
+}
+
+// format of the cache file
+type metadataCacheFile struct {
+	Searches map[string]*cachedSearch `json:"searches"`
+	Videos   map[string]*cachedVideo  `json:"videos"`
+}
+
+// cache used by youtube service. Kept in memory only until InitCache is called
+var Cache = &MetadataCache{
+	searches: make(map[string]*cachedSearch),
+	videos:   make(map[string]*cachedVideo),
+}
+
+// load cache from a file and save changes there periodically. A missing file
+// is created on first save. Flush needs to be called on shutdown to save
+// latest changes
+func InitCache(path string) error {
+	log.Printf("Initializing metadata cache from '%s'...", path)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		data, err = []byte("{}"), nil
+	}
+	if err != nil {
+		log.Printf("Failed to read metadata cache. Got error: [%s]", err.Error())
+		return err
+	}
+	cacheFile := metadataCacheFile{}
+	err = json.Unmarshal(data, &cacheFile)
+	if err != nil {
+		log.Printf("Failed to parse metadata cache. Got error: [%s]", err.Error())
+		return err
+	}
+
+	Cache.mtx.Lock()
+	defer Cache.mtx.Unlock()
+	Cache.path = path
+	now := time.Now()
+	for key, search := range cacheFile.Searches {
+		if search != nil && now.Before(search.Expires) {
+			Cache.searches[key] = search
+		}
+	}
+	for videoId, video := range cacheFile.Videos {
+		if video != nil && now.Before(video.Expires) {
+			Cache.videos[videoId] = video
+		}
+	}
+	log.Printf("Loaded %d search results and %d videos from metadata cache", len(Cache.searches), len(Cache.videos))
+	go Cache.flushPeriodically()
+	return nil
+}
+
+// lowercase query with single spaces, so that queries differing only in case
+// or spacing share results
+func normalizeQuery(query string) string {
+	return strings.Join(strings.Fields(strings.ToLower(query)), " ")
+}
+
+// get first resultNum video ids cached for a search key. Misses if fewer
+// results were cached, unless the source had no more results
+func (cache *MetadataCache) getSearch(key string, resultNum int) ([]string, bool) {
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+	search, ok := cache.searches[key]
+	if !ok || time.Now().After(search.Expires) || (len(search.VideoIds) < resultNum && !search.Complete) {
+		cache.stats.SearchMisses++
+		return nil, false
+	}
+	cache.stats.SearchHits++
+	if len(search.VideoIds) < resultNum {
+		resultNum = len(search.VideoIds)
+	}
+	return append([]string(nil), search.VideoIds[:resultNum]...), true
+}
+
+// cache video ids found for a search key when resultNum ids were asked for.
+// Fewer ids than asked for are all the results the source has
+func (cache *MetadataCache) setSearch(key string, videoIds []string, resultNum int) {
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+	cache.searches[key] = &cachedSearch{
+		VideoIds: videoIds,
+		Complete: len(videoIds) < resultNum,
+		Expires:  time.Now().Add(searchCacheTTL),
+	}
+	for len(cache.searches) > maxCacheEntries {
+		cache.evictSearch()
+	}
+	cache.dirty = true
+}
+
+// get cached metadata of a video
+func (cache *MetadataCache) getVideo(videoId string) (*cachedVideo, bool) {
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+	video, ok := cache.videos[videoId]
+	if !ok || time.Now().After(video.Expires) {
+		cache.stats.VideoMisses++
+		return nil, false
+	}
+	cache.stats.VideoHits++
+	return video, true
+}
+
+// cache metadata of videos by their id
+func (cache *MetadataCache) setVideos(videos map[string]*cachedVideo) {
+	if len(videos) == 0 {
+		return
+	}
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+	expires := time.Now().Add(videoCacheTTL)
+	for videoId, video := range videos {
+		video.Expires = expires
+		cache.videos[videoId] = video
+	}
+	for len(cache.videos) > maxCacheEntries {
+		cache.evictVideo()
+	}
+	cache.dirty = true
+}
+
+// remove search result expiring first. Must be called with mutex held
+func (cache *MetadataCache) evictSearch() {
+	firstKey := ""
+	for key, search := range cache.searches {
+		if firstKey == "" || search.Expires.Before(cache.searches[firstKey].Expires) {
+			firstKey = key
+		}
+	}
+	delete(cache.searches, firstKey)
+}
+
+// remove video expiring first. Must be called with mutex held
+func (cache *MetadataCache) evictVideo() {
+	firstId := ""
+	for videoId, video := range cache.videos {
+		if firstId == "" || video.Expires.Before(cache.videos[firstId].Expires) {
+			firstId = videoId
+		}
+	}
+	delete(cache.videos, firstId)
+}
+
+// save cache changes every cacheFlushInterval
+func (cache *MetadataCache) flushPeriodically() {
+	ticker := time.NewTicker(cacheFlushInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		cache.Flush()
+	}
+}
+
+// write cache to its file if it has changed since last write. Cache file is
+// replaced at once so that a crash while writing doesn't corrupt it
+func (cache *MetadataCache) Flush() {
+	// newer snapshots of the cache need to be written last
+	cache.saveMtx.Lock()
+	defer cache.saveMtx.Unlock()
+	cache.mtx.Lock()
+	if cache.path == "" || !cache.dirty {
+		cache.mtx.Unlock()
+		return
+	}
+	path := cache.path
+	data, err := json.Marshal(metadataCacheFile{Searches: cache.searches, Videos: cache.videos})
+	// changes made after this snapshot are saved by next flush
+	cache.dirty = false
+	cache.mtx.Unlock()
+	if err != nil {
+		log.Printf("Failed to encode metadata cache. Got error: [%s]", err.Error())
+		return
+	}
+
+	tmpPath := path + ".tmp"
+	err = os.WriteFile(tmpPath, data, 0644)
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		log.Printf("Failed to save metadata cache to '%s'. Got error: [%s]", path, err.Error())
+		// try again on next flush
+		cache.mtx.Lock()
+		cache.dirty = true
+		cache.mtx.Unlock()
+	}
+}
+
+// current size and hits of the cache
+func (cache *MetadataCache) Stats() CacheStats {
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+	stats := cache.stats
+	stats.SearchEntries = len(cache.searches)
+	stats.VideoEntries = len(cache.videos)
+	return stats
+}
@@ -0,0 +1,180 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package musicmanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Ar5h71/r4-music-bot/common"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// search endpoint used by youtube website. It needs no API key
+	innertubeSearchUrl = "https://www.youtube.com/youtubei/v1/search"
+	// web client sent to innertube
+	innertubeClientName    = "WEB"
+	innertubeClientVersion = "2.20240726.00.00"
+	// search filter for videos only
+	innertubeVideoFilter = "EgIQAQ=="
+	innertubeTimeout     = 10 * time.Second
+)
+
+// request body of innertube search
+type innertubeSearchRequest struct {
+	Context struct {
+		Client struct {
+			ClientName    string `json:"clientName"`
+			ClientVersion string `json:"clientVersion"`
+			Hl            string `json:"hl"`
+		} `json:"client"`
+	} `json:"context"`
+	Query  string `json:"query"`
+	Params string `json:"params"`
+}
+
+// text made of runs like title and channel name of a video
+type innertubeText struct {
+	SimpleText string `json:"simpleText"`
+	Runs       []struct {
+		Text               string `json:"text"`
+		NavigationEndpoint struct {
+			BrowseEndpoint struct {
+				BrowseId string `json:"browseId"`
+			} `json:"browseEndpoint"`
+		} `json:"navigationEndpoint"`
+	} `json:"runs"`
+}
+
+func (text innertubeText) String() string {
+	if text.SimpleText != "" {
+		return text.SimpleText
+	}
+	str := ""
+	for _, run := range text.Runs {
+		str += run.Text
+	}
+	return str
+}
+
+// video in innertube search results
+type innertubeVideo struct {
+	VideoId    string        `json:"videoId"`
+	Title      innertubeText `json:"title"`
+	OwnerText  innertubeText `json:"ownerText"`
+	LengthText innertubeText `json:"lengthText"`
+}
+
+// parts of innertube search response with the videos found
+type innertubeSearchResponse struct {
+	Contents struct {
+		TwoColumnSearchResultsRenderer struct {
+			PrimaryContents struct {
+				SectionListRenderer struct {
+					Contents []struct {
+						ItemSectionRenderer struct {
+							Contents []struct {
+								VideoRenderer *innertubeVideo `json:"videoRenderer"`
+							} `json:"contents"`
+						} `json:"itemSectionRenderer"`
+					} `json:"contents"`
+				} `json:"sectionListRenderer"`
+			} `json:"primaryContents"`
+		} `json:"twoColumnSearchResultsRenderer"`
+	} `json:"contents"`
+}
+
+// search videos with the search endpoint of youtube website. Used when data
+// API can't be used. Returns video ids in order with their metadata
+func innertubeSearch(query string, resultNum int) ([]string, map[string]*cachedVideo, error) {
+	request := innertubeSearchRequest{Query: query, Params: innertubeVideoFilter}
+	request.Context.Client.ClientName = innertubeClientName
+	request.Context.Client.ClientVersion = innertubeClientVersion
+	request.Context.Client.Hl = "en"
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), innertubeTimeout)
+	defer cancel()
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, innertubeSearchUrl, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(httpRequest)
+	if err != nil {
+		log.Printf("Failed to search youtube website for query [%s]. Got error [%s]", query, err.Error())
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		log.Printf("Failed to search youtube website for query [%s]. Got status [%s]", query, response.Status)
+		return nil, nil, fmt.Errorf("Search failed with status %s", response.Status)
+	}
+	searchResponse := innertubeSearchResponse{}
+	err = json.NewDecoder(response.Body).Decode(&searchResponse)
+	if err != nil {
+		log.Printf("Failed to parse youtube website search response for query [%s]. Got error [%s]", query, err.Error())
+		return nil, nil, err
+	}
+
+	videoIds := make([]string, 0, resultNum)
+	videos := make(map[string]*cachedVideo, resultNum)
+	sections := searchResponse.Contents.TwoColumnSearchResultsRenderer.PrimaryContents.SectionListRenderer.Contents
+	for _, section := range sections {
+		for _, item := range section.ItemSectionRenderer.Contents {
+			video := item.VideoRenderer
+			if video == nil || video.VideoId == "" || videos[video.VideoId] != nil || len(videoIds) == resultNum {
+				continue
+			}
+			videoIds = append(videoIds, video.VideoId)
+			videos[video.VideoId] = video.metadata()
+		}
+	}
+	if len(videoIds) == 0 {
+		log.Printf("No results found on youtube website for the query: %s", query)
+		return nil, nil, fmt.Errorf("No songs found for this query")
+	}
+	return videoIds, videos, nil
+}
+
+// metadata of a video in search results. Live streams have no length
+func (video *innertubeVideo) metadata() *cachedVideo {
+	metadata := &cachedVideo{
+		Title:       video.Title.String(),
+		ChannelName: video.OwnerText.String(),
+	}
+	if len(video.OwnerText.Runs) > 0 {
+		metadata.ChannelId = video.OwnerText.Runs[0].NavigationEndpoint.BrowseEndpoint.BrowseId
+	}
+	if length := video.LengthText.String(); length != "" {
+		metadata.Duration, _ = common.ParseTimestamp(length)
+	}
+	return metadata
+}
+
+// check if data API failed because the daily quota ran out
+func isQuotaError(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusForbidden {
+		return false
+	}
+	for _, item := range apiErr.Errors {
+		if item.Reason == "quotaExceeded" || item.Reason == "dailyLimitExceeded" {
+			return true
+		}
+	}
+	return false
+}
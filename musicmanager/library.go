@@ -0,0 +1,331 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package musicmanager
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/Ar5h71/r4-music-bot/common"
+)
+
+const (
+	// source name of library tracks
+	LibrarySourceName = "library"
+	// prefix of song ids of library tracks
+	LibrarySongIdPrefix = LibrarySourceName + ":"
+)
+
+// extensions of audio files indexed by the library
+var libraryExtensions = map[string]bool{
+	".mp3":  true,
+	".flac": true,
+	".ogg":  true,
+	".oga":  true,
+	".opus": true,
+}
+
+// a track in the local library
+type libraryTrack struct {
+	path          string
+	relPath       string
+	title         string
+	artist        string
+	album         string
+	duration      time.Duration
+	replayGain    float64
+	hasReplayGain bool
+	// lowercase words of title, artist, album and file name for matching
+	words []string
+	// lowercase words of title
+	titleWords []string
+}
+
+// index of audio files in a local directory. Provides songs from local files
+type LocalLibrary struct {
+	mtx    sync.RWMutex
+	dir    string
+	tracks []*libraryTrack
+}
+
+// local library, nil if no library directory is configured
+var Library *LocalLibrary
+
+// scan library directory and index its tracks
+func InitLibrary(dir string) error {
+	log.Printf("Initializing local library from '%s'...", dir)
+	library := &LocalLibrary{dir: dir}
+	err := library.Scan()
+	if err != nil {
+		return err
+	}
+	Library = library
+	return nil
+}
+
+// scan library directory for audio files and read their tags. Files with
+// unreadable tags are indexed by their file name
+func (library *LocalLibrary) Scan() error {
+	tracks := make([]*libraryTrack, 0)
+	err := filepath.WalkDir(library.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("Failed to read '%s' in library. Got error: %s", path, err.Error())
+			return nil
+		}
+		if entry.IsDir() || !libraryExtensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+		tracks = append(tracks, library.newTrack(path))
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to scan library '%s'. Got error: %s", library.dir, err.Error())
+		return err
+	}
+	sort.Slice(tracks, func(i, j int) bool {
+		return tracks[i].relPath < tracks[j].relPath
+	})
+
+	library.mtx.Lock()
+	library.tracks = tracks
+	library.mtx.Unlock()
+	log.Printf("Indexed %d tracks in library '%s'", len(tracks), library.dir)
+	return nil
+}
+
+// create a track for a file from its tags
+func (library *LocalLibrary) newTrack(path string) *libraryTrack {
+	relPath, err := filepath.Rel(library.dir, path)
+	if err != nil {
+		relPath = path
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		absPath = path
+	}
+	track := &libraryTrack{
+		path:    absPath,
+		relPath: filepath.ToSlash(relPath),
+	}
+	tags, err := readTags(path)
+	if err != nil {
+		log.Printf("Failed to read tags of '%s'. Got error: %s", path, err.Error())
+	} else {
+		track.title, track.artist, track.album = tags.title, tags.artist, tags.album
+		track.duration = tags.duration
+		track.replayGain, track.hasReplayGain = tags.replayGain, tags.hasReplayGain
+	}
+	fileName := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if track.title == "" {
+		track.title = fileName
+	}
+	track.words = searchWords(strings.Join([]string{track.title, track.artist, track.album, fileName}, " "))
+	track.titleWords = searchWords(track.title)
+	return track
+}
+
+// split text into lowercase words of letters and digits
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// score how well a track matches words of a query. Every query word needs to
+// be a prefix of a word of the track. Returns 0 if the track doesn't match
+func (track *libraryTrack) matchScore(queryWords []string) int {
+	score := 0
+	for _, queryWord := range queryWords {
+		best := 0
+		for _, word := range track.words {
+			switch {
+			case word == queryWord:
+				best = 2
+			case best == 0 && strings.HasPrefix(word, queryWord):
+				best = 1
+			}
+		}
+		if best == 0 {
+			return 0
+		}
+		score += best
+	}
+	return score
+}
+
+// check if a query names a track exactly. Every query word needs to be a
+// word of the track and every word of its title needs to be in the query,
+// like 'bohemian rhapsody' or 'queen bohemian rhapsody'
+func (track *libraryTrack) matchesExactly(queryWords []string) bool {
+	if !containsWords(track.words, queryWords) {
+		return false
+	}
+	return containsWords(queryWords, track.titleWords)
+}
+
+// check if all words are in a list of words
+func containsWords(list, words []string) bool {
+	for _, word := range words {
+		found := false
+		for _, listWord := range list {
+			if listWord == word {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (library *LocalLibrary) Name() string {
+	return LibrarySourceName
+}
+
+// library plays local files
+func (library *LocalLibrary) HandlesUrl(songUrl *url.URL) bool {
+	return songUrl.Scheme == "file"
+}
+
+// search library for tracks matching the query by title, artist, album or
+// file name. Returns at most resultNum songs, best matches first
+func (library *LocalLibrary) Search(query, userName string, resultNum int) ([]*common.Song, error) {
+	return library.search(query, userName, resultNum, false), nil
+}
+
+// search library for tracks named exactly by the query. Used when query isn't
+// prefixed with the library source, so that loose matches don't shadow youtube
+func (library *LocalLibrary) SearchExact(query, userName string, resultNum int) ([]*common.Song, error) {
+	return library.search(query, userName, resultNum, true), nil
+}
+
+func (library *LocalLibrary) search(query, userName string, resultNum int, exact bool) []*common.Song {
+	queryWords := searchWords(query)
+	if len(queryWords) == 0 {
+		return nil
+	}
+
+	library.mtx.RLock()
+	defer library.mtx.RUnlock()
+	type match struct {
+		track *libraryTrack
+		score int
+	}
+	matches := make([]match, 0)
+	for _, track := range library.tracks {
+		if exact && !track.matchesExactly(queryWords) {
+			continue
+		}
+		score := track.matchScore(queryWords)
+		if score > 0 {
+			// tracks with fewer other words match more closely
+			score = score*100 - len(track.words)
+			matches = append(matches, match{track: track, score: score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+	songs := make([]*common.Song, 0, resultNum)
+	for _, match := range matches {
+		if len(songs) == resultNum {
+			break
+		}
+		songs = append(songs, match.track.song(userName))
+	}
+	return songs
+}
+
+// get song for a file url of an indexed track
+func (library *LocalLibrary) Resolve(songUrl, userName string) (*common.Song, error) {
+	parsedUrl, err := url.Parse(songUrl)
+	if err != nil {
+		return nil, err
+	}
+	path, err := filepath.Abs(parsedUrl.Path)
+	if err != nil {
+		return nil, err
+	}
+	library.mtx.RLock()
+	defer library.mtx.RUnlock()
+	for _, track := range library.tracks {
+		if track.path == path {
+			return track.song(userName), nil
+		}
+	}
+	return nil, fmt.Errorf("'%s' is not in the library", path)
+}
+
+// get tracks of the same album, followed by other tracks of the same artist
+func (library *LocalLibrary) Related(song *common.Song, userName string, resultNum int) ([]*common.Song, error) {
+	library.mtx.RLock()
+	defer library.mtx.RUnlock()
+	songs := make([]*common.Song, 0, resultNum)
+	added := map[string]bool{song.SongId: true}
+	sameAlbum := func(track *libraryTrack) bool {
+		return song.Album != "" && track.album == song.Album
+	}
+	sameArtist := func(track *libraryTrack) bool {
+		return song.ChannelName != "" && track.artist == song.ChannelName
+	}
+	for _, related := range []func(*libraryTrack) bool{sameAlbum, sameArtist} {
+		for _, track := range library.tracks {
+			if len(songs) == resultNum {
+				return songs, nil
+			}
+			if !added[track.id()] && related(track) {
+				added[track.id()] = true
+				songs = append(songs, track.song(userName))
+			}
+		}
+	}
+	if len(songs) == 0 {
+		return nil, fmt.Errorf("No related songs found in library")
+	}
+	return songs, nil
+}
+
+// local files are played from their path
+func (library *LocalLibrary) StreamURL(song *common.Song) (*common.StreamInfo, error) {
+	library.mtx.RLock()
+	defer library.mtx.RUnlock()
+	for _, track := range library.tracks {
+		if track.id() == song.SongId {
+			return &common.StreamInfo{Url: track.path}, nil
+		}
+	}
+	return nil, fmt.Errorf("'%s' is not in the library", strings.TrimPrefix(song.SongId, LibrarySongIdPrefix))
+}
+
+// song id of the track
+func (track *libraryTrack) id() string {
+	return LibrarySongIdPrefix + track.relPath
+}
+
+// create a song to play the track
+func (track *libraryTrack) song(userName string) *common.Song {
+	return &common.Song{
+		SongId:        track.id(),
+		SongTitle:     track.title,
+		SongDuration:  track.duration,
+		User:          userName,
+		Source:        LibrarySourceName,
+		ChannelName:   track.artist,
+		Album:         track.album,
+		ReplayGain:    track.replayGain,
+		HasReplayGain: track.hasReplayGain,
+	}
+}
@@ -0,0 +1,164 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package musicmanager
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net/url"
+	"strings"
+
+	"google.golang.org/api/youtube/v3"
+)
+
+const (
+	// max items returned in a page of playlistItems.list
+	playlistPageSize = 50
+	// max entries of a playlist read. Big playlists are cut at this size
+	maxPlaylistEntries = 1000
+	// prefix of auto generated mix playlists, which the data API can't list
+	mixPlaylistPrefix = "RD"
+	// titles of playlist entries whose video is gone
+	deletedVideoTitle = "Deleted video"
+	privateVideoTitle = "Private video"
+)
+
+// youtube urls with a 'list' parameter are playlists, like
+// 'youtube.com/playlist?list=...' and 'youtube.com/watch?v=...&list=...'
+func (ytservice *YTService) IsPlaylistUrl(songUrl *url.URL) bool {
+	playlistId := songUrl.Query().Get("list")
+	return playlistId != "" && !strings.HasPrefix(playlistId, mixPlaylistPrefix)
+}
+
+// get songs of a youtube playlist. Private and deleted entries and videos
+// which are blocked are counted as unavailable
+func (ytservice *YTService) ResolvePlaylist(playlistUrl, userName string, limit int, shuffle bool) (*Playlist, error) {
+	parsedUrl, err := url.Parse(playlistUrl)
+	if err != nil {
+		return nil, err
+	}
+	playlistId := parsedUrl.Query().Get("list")
+	if ytservice.ytService == nil {
+		return nil, errNoApiKey
+	}
+
+	// all entries are needed to pick random songs
+	maxEntries := limit
+	if shuffle {
+		maxEntries = maxPlaylistEntries
+	}
+	entries, err := ytservice.playlistEntries(playlistId, maxEntries)
+	if err != nil {
+		return nil, err
+	}
+	if shuffle {
+		rand.Shuffle(len(entries), func(i, j int) {
+			entries[i], entries[j] = entries[j], entries[i]
+		})
+	}
+	videoIds, unavailable := takePlaylistVideos(entries, limit)
+
+	songs, err := ytservice.songsForVideoIds(videoIds, userName)
+	if err != nil {
+		return nil, err
+	}
+	if len(songs) == 0 {
+		return nil, fmt.Errorf("No playable songs found in playlist")
+	}
+	return &Playlist{
+		Title:       ytservice.playlistTitle(playlistId),
+		Songs:       songs,
+		Unavailable: unavailable + len(videoIds) - len(songs),
+	}, nil
+}
+
+// get video ids of entries in a playlist in order, reading pages until
+// maxEntries available videos are found. Private and deleted entries have an
+// empty id
+func (ytservice *YTService) playlistEntries(playlistId string, maxEntries int) ([]string, error) {
+	entries := make([]string, 0)
+	available := 0
+	pageToken := ""
+	for available < maxEntries {
+		call := ytservice.ytService.PlaylistItems.List([]string{"snippet", "contentDetails", "status"})
+		call.PlaylistId(playlistId).MaxResults(playlistPageSize)
+		if pageToken != "" {
+			call.PageToken(pageToken)
+		}
+		response, err := call.Do()
+		if err != nil {
+			log.Printf("[%s] Failed to list playlist items. Got error [%s]", playlistId, err.Error())
+			return nil, err
+		}
+		for _, item := range response.Items {
+			if available == maxEntries {
+				break
+			}
+			if !playlistItemAvailable(item) {
+				entries = append(entries, "")
+				continue
+			}
+			entries = append(entries, item.ContentDetails.VideoId)
+			available++
+		}
+		pageToken = response.NextPageToken
+		if pageToken == "" {
+			break
+		}
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("Playlist is empty")
+	}
+	return entries, nil
+}
+
+// take up to limit available videos from playlist entries in order. Returns
+// their ids and the number of unavailable entries before the limit was
+// reached, so entries which wouldn't be added anyway aren't counted
+func takePlaylistVideos(entries []string, limit int) ([]string, int) {
+	videoIds := make([]string, 0, limit)
+	unavailable := 0
+	for _, videoId := range entries {
+		if len(videoIds) == limit {
+			break
+		}
+		if videoId == "" {
+			unavailable++
+			continue
+		}
+		videoIds = append(videoIds, videoId)
+	}
+	return videoIds, unavailable
+}
+
+// check if a playlist entry still has a public or unlisted video
+func playlistItemAvailable(item *youtube.PlaylistItem) bool {
+	if item.ContentDetails == nil || item.ContentDetails.VideoId == "" {
+		return false
+	}
+	if item.Status != nil && item.Status.PrivacyStatus == "private" {
+		return false
+	}
+	if item.Snippet != nil && (item.Snippet.Title == deletedVideoTitle || item.Snippet.Title == privateVideoTitle) {
+		return false
+	}
+	return true
+}
+
+// get title of a playlist. Title is only shown to users so failures are
+// ignored
+func (ytservice *YTService) playlistTitle(playlistId string) string {
+	response, err := ytservice.ytService.Playlists.List([]string{"snippet"}).Id(playlistId).Do()
+	if err != nil {
+		log.Printf("[%s] Failed to get playlist title. Got error [%s]", playlistId, err.Error())
+		return ""
+	}
+	if len(response.Items) == 0 || response.Items[0].Snippet == nil {
+		return ""
+	}
+	return response.Items[0].Snippet.Title
+}
@@ -0,0 +1,33 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package musicmanager
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTakePlaylistVideos(t *testing.T) {
+	entries := []string{"a", "", "b", "", "c", "", "d"}
+	tests := []struct {
+		limit       int
+		videoIds    []string
+		unavailable int
+	}{
+		{limit: 1, videoIds: []string{"a"}, unavailable: 0},
+		{limit: 2, videoIds: []string{"a", "b"}, unavailable: 1},
+		// unavailable entries after the limit are not counted
+		{limit: 3, videoIds: []string{"a", "b", "c"}, unavailable: 2},
+		{limit: 10, videoIds: []string{"a", "b", "c", "d"}, unavailable: 3},
+	}
+	for _, test := range tests {
+		videoIds, unavailable := takePlaylistVideos(entries, test.limit)
+		if !reflect.DeepEqual(videoIds, test.videoIds) || unavailable != test.unavailable {
+			t.Errorf("takePlaylistVideos(limit %d) = %v, %d unavailable. Want %v, %d unavailable",
+				test.limit, videoIds, unavailable, test.videoIds, test.unavailable)
+		}
+	}
+}
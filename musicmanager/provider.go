@@ -0,0 +1,218 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package musicmanager
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/Ar5h71/r4-music-bot/common"
+)
+
+// a source of songs like youtube or the local library
+type Provider interface {
+	// name of the source. Songs of the source have it as their Source and
+	// queries prefixed with 'name:' only search this source
+	Name() string
+	// check if a song url belongs to this source
+	HandlesUrl(songUrl *url.URL) bool
+	// search songs matching the query, best matches first
+	Search(query, userName string, resultNum int) ([]*common.Song, error)
+	// get song for a url of this source
+	Resolve(songUrl, userName string) (*common.Song, error)
+	// get songs related to a song of this source
+	Related(song *common.Song, userName string, resultNum int) ([]*common.Song, error)
+	// get a fresh stream of a song of this source
+	StreamURL(song *common.Song) (*common.StreamInfo, error)
+}
+
+// a source which can also resolve playlist urls
+type PlaylistProvider interface {
+	Provider
+	// check if a url of this source is a playlist
+	IsPlaylistUrl(songUrl *url.URL) bool
+	// get songs of a playlist in playlist order, or in random order if shuffle
+	// is set. At most limit songs are resolved
+	ResolvePlaylist(playlistUrl, userName string, limit int, shuffle bool) (*Playlist, error)
+}
+
+// a source whose search matches loosely, like the local library. Queries
+// without a source prefix only use its exact matches, so that it doesn't
+// shadow results of other sources
+type ExactSearchProvider interface {
+	Provider
+	// search songs matching the whole query exactly, best matches first
+	SearchExact(query, userName string, resultNum int) ([]*common.Song, error)
+}
+
+// songs of a playlist
+type Playlist struct {
+	Title string
+	Songs []*common.Song
+	// entries which couldn't be added, like private or deleted videos
+	Unavailable int
+}
+
+var (
+	providersMtx sync.RWMutex
+	// providers in the order they are searched
+	providers []Provider
+)
+
+// register a source. Sources are searched in order of registration
+func RegisterProvider(provider Provider) {
+	providersMtx.Lock()
+	defer providersMtx.Unlock()
+	log.Printf("Registering music source '%s'", provider.Name())
+	providers = append(providers, provider)
+}
+
+// get a registered source by name
+func GetProvider(name string) (Provider, bool) {
+	providersMtx.RLock()
+	defer providersMtx.RUnlock()
+	for _, provider := range providers {
+		if strings.EqualFold(provider.Name(), name) {
+			return provider, true
+		}
+	}
+	return nil, false
+}
+
+// registered sources in search order
+func registeredProviders() []Provider {
+	providersMtx.RLock()
+	defer providersMtx.RUnlock()
+	return append([]Provider(nil), providers...)
+}
+
+// split a query like 'library: song name' into its source and the query.
+// Queries without a registered source prefix are searched in all sources.
+// Returns if the source was given by a prefix
+func parseQuery(query string) ([]Provider, string, bool) {
+	name, rest, ok := strings.Cut(query, ":")
+	if ok {
+		if provider, found := GetProvider(strings.TrimSpace(name)); found {
+			return []Provider{provider}, strings.TrimSpace(rest), true
+		}
+	}
+	return registeredProviders(), query, false
+}
+
+// check if a query has a source prefix like 'youtube:'. Such queries also
+// parse as urls, so they need to be told apart from song urls
+func HasSourcePrefix(query string) bool {
+	name, _, ok := strings.Cut(query, ":")
+	if !ok {
+		return false
+	}
+	_, found := GetProvider(strings.TrimSpace(name))
+	return found
+}
+
+// search songs in all sources, or in one source if the query has its prefix.
+// Results of earlier sources come first. Sources which fail are skipped as
+// long as some source finds songs
+func Search(query, userName string, resultNum int) ([]*common.Song, error) {
+	searchProviders, query, prefixed := parseQuery(query)
+	songs := make([]*common.Song, 0, resultNum)
+	var lastErr error
+	for _, provider := range searchProviders {
+		if len(songs) >= resultNum {
+			break
+		}
+		search := provider.Search
+		if exactProvider, ok := provider.(ExactSearchProvider); ok && !prefixed {
+			search = exactProvider.SearchExact
+		}
+		found, err := search(query, userName, resultNum-len(songs))
+		if err != nil {
+			log.Printf("Failed to search '%s' for query '%s'. Got error: %s", provider.Name(), query, err.Error())
+			lastErr = err
+			continue
+		}
+		songs = append(songs, found...)
+	}
+	if len(songs) == 0 {
+		if lastErr != nil {
+			return nil, lastErr
+		}
+		return nil, fmt.Errorf("No songs found for this query")
+	}
+	return songs, nil
+}
+
+// get song for a url from the source which handles its host
+func Resolve(songUrl, userName string) (*common.Song, error) {
+	parsedUrl, err := url.Parse(songUrl)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid url '%s'", songUrl)
+	}
+	for _, provider := range registeredProviders() {
+		if provider.HandlesUrl(parsedUrl) {
+			return provider.Resolve(songUrl, userName)
+		}
+	}
+	log.Printf("No music source for url '%s'", songUrl)
+	return nil, fmt.Errorf("Songs from '%s' are not supported", parsedUrl.Host)
+}
+
+// get songs related to a song from its source
+func Related(song *common.Song, userName string, resultNum int) ([]*common.Song, error) {
+	provider, ok := GetProvider(song.Source)
+	if !ok {
+		return nil, fmt.Errorf("Unknown source '%s' of song '%s'", song.Source, song.SongTitle)
+	}
+	return provider.Related(song, userName, resultNum)
+}
+
+// get a fresh stream of a song from its source. Songs in the audio file cache
+// are streamed from their file
+func StreamURL(song *common.Song) (*common.StreamInfo, error) {
+	if AudioFiles != nil {
+		if stream, ok := AudioFiles.stream(song); ok {
+			return stream, nil
+		}
+	}
+	provider, ok := GetProvider(song.Source)
+	if !ok {
+		return nil, fmt.Errorf("Unknown source '%s' of song '%s'", song.Source, song.SongTitle)
+	}
+	return provider.StreamURL(song)
+}
+
+// get the source which resolves a playlist url, if the url is a playlist
+func playlistProvider(songUrl string) (PlaylistProvider, bool) {
+	parsedUrl, err := url.Parse(songUrl)
+	if err != nil {
+		return nil, false
+	}
+	for _, provider := range registeredProviders() {
+		playlistProvider, ok := provider.(PlaylistProvider)
+		if ok && provider.HandlesUrl(parsedUrl) && playlistProvider.IsPlaylistUrl(parsedUrl) {
+			return playlistProvider, true
+		}
+	}
+	return nil, false
+}
+
+// check if a url is a playlist of a registered source
+func IsPlaylistUrl(songUrl string) bool {
+	_, ok := playlistProvider(songUrl)
+	return ok
+}
+
+// get songs of a playlist url from its source
+func ResolvePlaylist(playlistUrl, userName string, limit int, shuffle bool) (*Playlist, error) {
+	provider, ok := playlistProvider(playlistUrl)
+	if !ok {
+		return nil, fmt.Errorf("'%s' is not a playlist", playlistUrl)
+	}
+	return provider.ResolvePlaylist(playlistUrl, userName, limit, shuffle)
+}
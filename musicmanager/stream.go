@@ -0,0 +1,132 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package musicmanager
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// size of each range request when reading a stream. googlevideo throttles
+// requests which try to download the whole stream at once
+const streamChunkSize int64 = 10 * 1024 * 1024
+
+// stream urls are refreshed this long before they expire
+const streamUrlExpiryMargin = time.Minute
+
+// check if a googlevideo stream url has expired or is about to expire. Urls
+// without expiry never expire
+func StreamUrlExpired(streamUrl string) bool {
+	parsedUrl, err := url.Parse(streamUrl)
+	if err != nil {
+		return false
+	}
+	expire, err := strconv.ParseInt(parsedUrl.Query().Get("expire"), 10, 64)
+	if err != nil {
+		return false
+	}
+	return time.Now().Add(streamUrlExpiryMargin).After(time.Unix(expire, 0))
+}
+
+// reads a remote stream in chunks using HTTP range requests
+type chunkedStreamReader struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	streamUrl string
+	offset    int64
+	// bytes left in current chunk
+	chunkLeft int64
+	body      io.ReadCloser
+	eof       bool
+}
+
+// open a stream URL for reading. Closing the reader interrupts any pending read
+func OpenStream(streamUrl string) io.ReadCloser {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &chunkedStreamReader{
+		ctx:       ctx,
+		cancel:    cancel,
+		streamUrl: streamUrl,
+	}
+}
+
+func (stream *chunkedStreamReader) Read(buf []byte) (int, error) {
+	for {
+		if stream.eof {
+			return 0, io.EOF
+		}
+		if stream.body == nil {
+			err := stream.requestChunk()
+			if err != nil {
+				return 0, err
+			}
+			if stream.eof {
+				return 0, io.EOF
+			}
+		}
+		n, err := stream.body.Read(buf)
+		stream.offset += int64(n)
+		stream.chunkLeft -= int64(n)
+		if err == io.EOF {
+			stream.body.Close()
+			stream.body = nil
+			// a short chunk means the stream has ended
+			if stream.chunkLeft > 0 {
+				stream.eof = true
+			}
+			err = nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+// request next chunk of the stream
+func (stream *chunkedStreamReader) requestChunk() error {
+	request, err := http.NewRequestWithContext(stream.ctx, http.MethodGet, stream.streamUrl, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", stream.offset, stream.offset+streamChunkSize-1))
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	switch response.StatusCode {
+	case http.StatusPartialContent:
+		stream.chunkLeft = streamChunkSize
+	case http.StatusOK:
+		// server ignored range and sends whole stream
+		stream.chunkLeft = response.ContentLength
+		if stream.chunkLeft < 0 {
+			stream.chunkLeft = 1<<63 - 1
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		response.Body.Close()
+		stream.eof = true
+		return nil
+	default:
+		response.Body.Close()
+		log.Printf("Failed to read stream chunk at offset %d. Got status: %s", stream.offset, response.Status)
+		return fmt.Errorf("Unexpected status '%s' for stream", response.Status)
+	}
+	stream.body = response.Body
+	return nil
+}
+
+// cancel the pending request. Safe to call while a read is in progress
+func (stream *chunkedStreamReader) Close() error {
+	stream.cancel()
+	return nil
+}
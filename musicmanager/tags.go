@@ -0,0 +1,549 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package musicmanager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// tags of a local audio file
+type audioTags struct {
+	title    string
+	artist   string
+	album    string
+	duration time.Duration
+	// ReplayGain track gain in dB
+	replayGain    float64
+	hasReplayGain bool
+}
+
+// ReplayGain is 5dB louder than R128 gain of opus files, which targets -23 LUFS
+const r128ToReplayGainDb = 5
+
+// max size of a tag or metadata block read into memory. Larger blocks are
+// usually embedded cover art
+const maxTagSize = 16 * 1024 * 1024
+
+// read tags of an MP3, FLAC or Ogg file
+func readTags(path string) (*audioTags, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	magic := make([]byte, 4)
+	_, err = io.ReadFull(file, magic)
+	if err != nil {
+		return nil, fmt.Errorf("File is too short")
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case string(magic) == "fLaC":
+		return readFlacTags(file)
+	case string(magic) == "OggS":
+		return readOggTags(file, stat.Size())
+	default:
+		return readMp3Tags(file, stat.Size())
+	}
+}
+
+// set a tag from a vorbis comment or ID3 text frame with the given name
+func (tags *audioTags) setTag(name, value string) {
+	value = strings.TrimSpace(value)
+	switch strings.ToUpper(name) {
+	case "TITLE", "TIT2":
+		tags.title = value
+	case "ARTIST", "TPE1":
+		tags.artist = value
+	case "ALBUM", "TALB":
+		tags.album = value
+	case "TLEN":
+		if ms, err := strconv.Atoi(value); err == nil && tags.duration == 0 {
+			tags.duration = time.Duration(ms) * time.Millisecond
+		}
+	case "REPLAYGAIN_TRACK_GAIN":
+		gain, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(strings.ToUpper(value), "DB")), 64)
+		if err == nil {
+			tags.replayGain, tags.hasReplayGain = gain, true
+		}
+	case "R128_TRACK_GAIN":
+		// Q7.8 fixed point gain in dB
+		gain, err := strconv.Atoi(value)
+		if err == nil && !tags.hasReplayGain {
+			tags.replayGain, tags.hasReplayGain = float64(gain)/256+r128ToReplayGainDb, true
+		}
+	}
+}
+
+// parse a vorbis comment block used by FLAC and Ogg files
+func (tags *audioTags) parseVorbisComment(data []byte) error {
+	reader := bytes.NewReader(data)
+	var vendorLength uint32
+	err := binary.Read(reader, binary.LittleEndian, &vendorLength)
+	if err != nil {
+		return err
+	}
+	_, err = reader.Seek(int64(vendorLength), io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	var count uint32
+	err = binary.Read(reader, binary.LittleEndian, &count)
+	if err != nil {
+		return err
+	}
+	for idx := uint32(0); idx < count; idx++ {
+		var length uint32
+		err = binary.Read(reader, binary.LittleEndian, &length)
+		if err != nil || int64(length) > int64(reader.Len()) {
+			return fmt.Errorf("Invalid vorbis comment")
+		}
+		comment := make([]byte, length)
+		_, err = io.ReadFull(reader, comment)
+		if err != nil {
+			return err
+		}
+		name, value, ok := strings.Cut(string(comment), "=")
+		if ok {
+			tags.setTag(name, value)
+		}
+	}
+	return nil
+}
+
+// read vorbis comments and duration from STREAMINFO of a FLAC file
+func readFlacTags(file io.Reader) (*audioTags, error) {
+	tags := &audioTags{}
+	_, err := io.CopyN(io.Discard, file, 4)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		header := make([]byte, 4)
+		_, err = io.ReadFull(file, header)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid FLAC metadata")
+		}
+		last := header[0]&0x80 != 0
+		blockType := header[0] & 0x7F
+		length := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		if blockType != 0 && blockType != 4 || length > maxTagSize {
+			_, err = io.CopyN(io.Discard, file, length)
+		} else {
+			block := make([]byte, length)
+			_, err = io.ReadFull(file, block)
+			if err == nil && blockType == 0 && len(block) >= 18 {
+				// 20 bits sample rate and 36 bits total samples
+				sampleRate := int64(block[10])<<12 | int64(block[11])<<4 | int64(block[12])>>4
+				samples := int64(block[13]&0x0F)<<32 | int64(binary.BigEndian.Uint32(block[14:18]))
+				if sampleRate > 0 {
+					tags.duration = time.Duration(samples) * time.Second / time.Duration(sampleRate)
+				}
+			}
+			if err == nil && blockType == 4 {
+				err = tags.parseVorbisComment(block)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid FLAC metadata")
+		}
+		if last {
+			return tags, nil
+		}
+	}
+}
+
+// reads packets of the first logical stream of an Ogg file
+type OggPacketReader struct {
+	reader  io.Reader
+	serial  uint32
+	started bool
+	// segments of current page not read yet
+	segments []byte
+}
+
+func NewOggPacketReader(reader io.Reader) *OggPacketReader {
+	return &OggPacketReader{reader: reader}
+}
+
+// read next packet. Packets can span pages. Returns io.EOF at end of stream
+func (ogg *OggPacketReader) ReadPacket() ([]byte, error) {
+	var packet []byte
+	for {
+		if len(ogg.segments) == 0 {
+			err := ogg.readPage()
+			if err == io.EOF && len(packet) > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		size := int(ogg.segments[0])
+		ogg.segments = ogg.segments[1:]
+		if len(packet)+size > maxTagSize {
+			return nil, fmt.Errorf("Ogg packet is too large")
+		}
+		segment := make([]byte, size)
+		_, err := io.ReadFull(ogg.reader, segment)
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		packet = append(packet, segment...)
+		// a segment shorter than 255 bytes ends the packet
+		if size < 255 {
+			return packet, nil
+		}
+	}
+}
+
+// read header of next page of the stream, skipping pages of other streams
+func (ogg *OggPacketReader) readPage() error {
+	for {
+		var header struct {
+			Capture  [4]byte
+			Version  uint8
+			Type     uint8
+			Granule  int64
+			Serial   uint32
+			Sequence uint32
+			Checksum uint32
+			Segments uint8
+		}
+		err := binary.Read(ogg.reader, binary.LittleEndian, &header)
+		if err == io.EOF {
+			return io.EOF
+		}
+		if err != nil || string(header.Capture[:]) != "OggS" {
+			return fmt.Errorf("Invalid Ogg page")
+		}
+		segments := make([]byte, header.Segments)
+		_, err = io.ReadFull(ogg.reader, segments)
+		if err != nil {
+			return err
+		}
+		if !ogg.started {
+			ogg.serial, ogg.started = header.Serial, true
+		}
+		if header.Serial == ogg.serial {
+			ogg.segments = segments
+			return nil
+		}
+		size := 0
+		for _, segment := range segments {
+			size += int(segment)
+		}
+		_, err = io.CopyN(io.Discard, ogg.reader, int64(size))
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// read comments of an Ogg Vorbis or Ogg Opus file. Duration is taken from
+// granule position of the last page
+func readOggTags(file io.ReadSeeker, size int64) (*audioTags, error) {
+	tags := &audioTags{}
+	ogg := NewOggPacketReader(file)
+	ident, err := ogg.ReadPacket()
+	if err != nil {
+		return nil, err
+	}
+	comment, err := ogg.ReadPacket()
+	if err != nil {
+		return nil, err
+	}
+
+	var sampleRate, preSkip int64
+	switch {
+	case bytes.HasPrefix(ident, []byte("OpusHead")) && len(ident) >= 19:
+		// granule position of opus is always at 48KHz
+		sampleRate = int64(frameRate)
+		preSkip = int64(binary.LittleEndian.Uint16(ident[10:12]))
+		if !bytes.HasPrefix(comment, []byte("OpusTags")) {
+			return nil, fmt.Errorf("Opus comment header not found")
+		}
+		err = tags.parseVorbisComment(comment[8:])
+	case bytes.HasPrefix(ident, []byte("\x01vorbis")) && len(ident) >= 16:
+		sampleRate = int64(binary.LittleEndian.Uint32(ident[12:16]))
+		if !bytes.HasPrefix(comment, []byte("\x03vorbis")) {
+			return nil, fmt.Errorf("Vorbis comment header not found")
+		}
+		err = tags.parseVorbisComment(comment[7:])
+	default:
+		return nil, fmt.Errorf("Ogg file is neither Vorbis nor Opus")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	granule, err := lastOggGranule(file, size, ogg.serial)
+	if err == nil && sampleRate > 0 && granule > preSkip {
+		tags.duration = time.Duration(granule-preSkip) * time.Second / time.Duration(sampleRate)
+	}
+	return tags, nil
+}
+
+// find granule position of the last page of a stream near the end of the file
+func lastOggGranule(file io.ReadSeeker, size int64, serial uint32) (int64, error) {
+	// pages are at most about 64KB
+	tailSize := int64(128 * 1024)
+	if tailSize > size {
+		tailSize = size
+	}
+	_, err := file.Seek(size-tailSize, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	tail := make([]byte, tailSize)
+	_, err = io.ReadFull(file, tail)
+	if err != nil {
+		return 0, err
+	}
+	for idx := bytes.LastIndex(tail, []byte("OggS")); idx >= 0; idx = bytes.LastIndex(tail[:idx], []byte("OggS")) {
+		if idx+18 > len(tail) {
+			continue
+		}
+		granule := int64(binary.LittleEndian.Uint64(tail[idx+6 : idx+14]))
+		if binary.LittleEndian.Uint32(tail[idx+14:idx+18]) == serial && granule >= 0 {
+			return granule, nil
+		}
+	}
+	return 0, fmt.Errorf("Last Ogg page not found")
+}
+
+// read ID3v2 or ID3v1 tags of an MP3 file. Duration is taken from TLEN frame
+// or the first MPEG frame
+func readMp3Tags(file io.ReadSeeker, size int64) (*audioTags, error) {
+	tags := &audioTags{}
+	audioStart, err := tags.readId3v2(file)
+	if err != nil {
+		return nil, err
+	}
+	if tags.title == "" {
+		tags.readId3v1(file, size)
+	}
+	if tags.duration == 0 {
+		tags.duration = mp3Duration(file, audioStart, size)
+	}
+	return tags, nil
+}
+
+// read ID3v2.3 or ID3v2.4 tag at start of a file. Returns offset of audio
+// after the tag
+func (tags *audioTags) readId3v2(file io.ReadSeeker) (int64, error) {
+	header := make([]byte, 10)
+	_, err := io.ReadFull(file, header)
+	if err != nil || string(header[:3]) != "ID3" {
+		return 0, nil
+	}
+	version := header[3]
+	tagSize := syncsafe(header[6:10])
+	audioStart := 10 + tagSize
+	if header[5]&0x10 != 0 {
+		// footer
+		audioStart += 10
+	}
+	if (version != 3 && version != 4) || tagSize > maxTagSize {
+		return audioStart, nil
+	}
+	data := make([]byte, tagSize)
+	_, err = io.ReadFull(file, data)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid ID3 tag")
+	}
+	if header[5]&0x40 != 0 && len(data) >= 4 {
+		// skip extended header
+		extSize := int64(binary.BigEndian.Uint32(data[:4]))
+		if version == 4 {
+			extSize = syncsafe(data[:4])
+		} else {
+			extSize += 4
+		}
+		if extSize > int64(len(data)) {
+			return audioStart, nil
+		}
+		data = data[extSize:]
+	}
+
+	for len(data) >= 10 && data[0] != 0 {
+		id := string(data[:4])
+		frameSize := int64(binary.BigEndian.Uint32(data[4:8]))
+		if version == 4 {
+			frameSize = syncsafe(data[4:8])
+		}
+		if frameSize > int64(len(data)-10) {
+			break
+		}
+		frame := data[10 : 10+frameSize]
+		data = data[10+frameSize:]
+		if len(frame) == 0 {
+			continue
+		}
+		switch {
+		case id == "TXXX":
+			description, value, ok := strings.Cut(decodeId3Text(frame[0], frame[1:]), "\x00")
+			if ok {
+				tags.setTag(description, value)
+			}
+		case strings.HasPrefix(id, "T"):
+			tags.setTag(id, decodeId3Text(frame[0], frame[1:]))
+		}
+	}
+	return audioStart, nil
+}
+
+// read ID3v1 tag at end of a file
+func (tags *audioTags) readId3v1(file io.ReadSeeker, size int64) {
+	if size < 128 {
+		return
+	}
+	_, err := file.Seek(size-128, io.SeekStart)
+	if err != nil {
+		return
+	}
+	tag := make([]byte, 128)
+	_, err = io.ReadFull(file, tag)
+	if err != nil || string(tag[:3]) != "TAG" {
+		return
+	}
+	field := func(data []byte) string {
+		return strings.TrimSpace(strings.TrimRight(string(data), "\x00"))
+	}
+	tags.title = field(tag[3:33])
+	tags.artist = field(tag[33:63])
+	tags.album = field(tag[63:93])
+}
+
+// decode 28 bit syncsafe integer of ID3v2
+func syncsafe(data []byte) int64 {
+	return int64(data[0]&0x7F)<<21 | int64(data[1]&0x7F)<<14 | int64(data[2]&0x7F)<<7 | int64(data[3]&0x7F)
+}
+
+// decode text of an ID3v2 frame with given encoding. Multiple values are
+// separated by null characters
+func decodeId3Text(encoding byte, data []byte) string {
+	var text string
+	switch encoding {
+	case 1, 2:
+		bigEndian := encoding == 2
+		if len(data) >= 2 && data[0] == 0xFE && data[1] == 0xFF {
+			bigEndian, data = true, data[2:]
+		} else if len(data) >= 2 && data[0] == 0xFF && data[1] == 0xFE {
+			bigEndian, data = false, data[2:]
+		}
+		units := make([]uint16, 0, len(data)/2)
+		for idx := 0; idx+1 < len(data); idx += 2 {
+			if bigEndian {
+				units = append(units, binary.BigEndian.Uint16(data[idx:]))
+			} else {
+				units = append(units, binary.LittleEndian.Uint16(data[idx:]))
+			}
+		}
+		text = string(utf16.Decode(units))
+		// BOM of values after the first one
+		text = strings.ReplaceAll(text, "\uFEFF", "")
+	case 3:
+		text = string(data)
+	default:
+		// ISO-8859-1 maps directly to unicode code points
+		runes := make([]rune, len(data))
+		for idx, b := range data {
+			runes[idx] = rune(b)
+		}
+		text = string(runes)
+	}
+	return strings.TrimRight(text, "\x00")
+}
+
+// bitrates in kbps of MPEG layer III by bitrate index
+var (
+	mpeg1Bitrates = [16]int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}
+	mpeg2Bitrates = [16]int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0}
+	mpegRates     = [3]int{44100, 48000, 32000}
+)
+
+// duration of an MP3 file from Xing or VBRI header of the first frame, or
+// from its bitrate for constant bitrate files. Returns 0 if unknown
+func mp3Duration(file io.ReadSeeker, audioStart, size int64) time.Duration {
+	_, err := file.Seek(audioStart, io.SeekStart)
+	if err != nil {
+		return 0
+	}
+	// first frame is expected within a few KB of the tag
+	data := make([]byte, 64*1024)
+	n, _ := io.ReadFull(file, data)
+	data = data[:n]
+	for idx := 0; idx+4 <= len(data); idx++ {
+		if data[idx] != 0xFF || data[idx+1]&0xE0 != 0xE0 {
+			continue
+		}
+		version := (data[idx+1] >> 3) & 0x03
+		layer := (data[idx+1] >> 1) & 0x03
+		bitrateIdx := data[idx+2] >> 4
+		rateIdx := (data[idx+2] >> 2) & 0x03
+		// only layer III with valid version, bitrate and sample rate
+		if version == 1 || layer != 1 || bitrateIdx == 0 || bitrateIdx == 15 || rateIdx == 3 {
+			continue
+		}
+		mono := data[idx+3]>>6 == 3
+		sampleRate := mpegRates[rateIdx]
+		bitrate := mpeg1Bitrates[bitrateIdx]
+		samplesPerFrame := 1152
+		sideInfo := 32
+		if mono {
+			sideInfo = 17
+		}
+		if version != 3 {
+			// MPEG 2 and 2.5
+			bitrate = mpeg2Bitrates[bitrateIdx]
+			samplesPerFrame = 576
+			sampleRate /= 2
+			if version == 0 {
+				sampleRate /= 2
+			}
+			sideInfo = 17
+			if mono {
+				sideInfo = 9
+			}
+		}
+
+		frame := data[idx:]
+		xing := 4 + sideInfo
+		if len(frame) >= xing+12 && (string(frame[xing:xing+4]) == "Xing" || string(frame[xing:xing+4]) == "Info") &&
+			binary.BigEndian.Uint32(frame[xing+4:xing+8])&0x01 != 0 {
+			frames := int64(binary.BigEndian.Uint32(frame[xing+8 : xing+12]))
+			return time.Duration(frames*int64(samplesPerFrame)) * time.Second / time.Duration(sampleRate)
+		}
+		if len(frame) >= 36+18 && string(frame[36:40]) == "VBRI" {
+			frames := int64(binary.BigEndian.Uint32(frame[36+14 : 36+18]))
+			return time.Duration(frames*int64(samplesPerFrame)) * time.Second / time.Duration(sampleRate)
+		}
+		audioSize := size - audioStart - int64(idx)
+		return time.Duration(audioSize*8) * time.Millisecond / time.Duration(bitrate)
+	}
+	return 0
+}
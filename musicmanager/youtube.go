@@ -10,9 +10,10 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/Ar5h71/r4-music-bot/common"
@@ -21,18 +22,50 @@ import (
 	"google.golang.org/api/youtube/v3"
 )
 
+const (
+	// source name of youtube songs
+	YoutubeSourceName = "youtube"
+	// max video ids in a videos.list request
+	maxVideosPerRequest = 50
+	// cache keys of searches by query and of songs related to a video
+	searchCacheKeyPrefix  = "search:"
+	relatedCacheKeyPrefix = "related:"
+)
+
+// related songs and playlists can only be found with data API
+var errNoApiKey = errors.New("This needs a youtube API key")
+
+// ISO 8601 durations of videos, like 'PT4M13S' or 'P1DT2H'
+var isoDurationRegex = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// hosts of youtube urls
+var youtubeHosts = map[string]bool{
+	"youtube.com":       true,
+	"www.youtube.com":   true,
+	"m.youtube.com":     true,
+	"music.youtube.com": true,
+	"youtu.be":          true,
+}
+
+// provides songs from youtube. Data API is used to search and stream urls
+// are fetched by the download client. Without an API key, or when its quota
+// runs out, youtube website is searched instead
 type YTService struct {
-	ytService *youtube.Service
+	// nil if no API key is configured
+	ytService      *youtube.Service
+	downloadClient *youtubedr.Client
 }
 
-var (
-	YtServiceClient = &YTService{}
-	downloadClient  = &youtubedr.Client{}
-)
+var YtServiceClient = &YTService{}
 
-// init youtube service client
+// init youtube service client. Data API is not used if API key is empty
 func InitYoutubeClient(youtubeAPIKey string) error {
 	log.Printf("Initializing youtube client...")
+	YtServiceClient.downloadClient = &youtubedr.Client{}
+	if youtubeAPIKey == "" {
+		log.Printf("No youtube API key, searching youtube website instead of data API")
+		return nil
+	}
 	ctx := context.Background()
 	var err error
 	YtServiceClient.ytService, err = youtube.NewService(ctx, option.WithAPIKey(youtubeAPIKey))
@@ -43,123 +76,323 @@ func InitYoutubeClient(youtubeAPIKey string) error {
 	return nil
 }
 
-// Search single or multiple results
-func (ytservice *YTService) Search(query, userName string, resultNum int64) ([]*common.Song, error) {
-	// search for the query
-	ytServiceSearchListCall := ytservice.ytService.Search.List([]string{"id"})
-	ytServiceSearchListCall.Q(query).Type("video").VideoCategoryId("10").MaxResults(resultNum)
-	ytSearchResponse, err := ytServiceSearchListCall.Do()
-	if err != nil {
+func (ytservice *YTService) Name() string {
+	return YoutubeSourceName
+}
+
+func (ytservice *YTService) HandlesUrl(songUrl *url.URL) bool {
+	return youtubeHosts[strings.ToLower(songUrl.Hostname())]
+}
+
+// Search single or multiple results. Only metadata of the songs is fetched,
+// stream urls are fetched when a song is played. Results are cached as search
+// costs a lot of API quota
+func (ytservice *YTService) Search(query, userName string, resultNum int) ([]*common.Song, error) {
+	cacheKey := searchCacheKeyPrefix + normalizeQuery(query)
+	videoIds, ok := Cache.getSearch(cacheKey, resultNum)
+	if !ok {
+		var err error
+		videoIds, err = ytservice.searchVideoIds(query, resultNum)
+		if err != nil {
+			return nil, err
+		}
+		Cache.setSearch(cacheKey, videoIds, resultNum)
+	}
+	return ytservice.songsForSearchResults(videoIds, userName)
+}
+
+// search video ids for the query with data API, falling back to youtube
+// website if there is no API key or quota has run out
+func (ytservice *YTService) searchVideoIds(query string, resultNum int) ([]string, error) {
+	if ytservice.ytService != nil {
+		// search for the query
+		ytServiceSearchListCall := ytservice.ytService.Search.List([]string{"id"})
+		ytServiceSearchListCall.Q(query).Type("video").VideoCategoryId("10").MaxResults(int64(resultNum))
+		ytSearchResponse, err := ytServiceSearchListCall.Do()
+		if err == nil {
+			if len(ytSearchResponse.Items) == 0 {
+				log.Printf("No results found for the query: %s", query)
+				return nil, fmt.Errorf("No songs found for this query")
+			}
+			return searchResultIds(ytSearchResponse.Items), nil
+		}
 		log.Printf("Failed to search for query [%s]. Got error [%s]", query, err.Error())
+		if !isQuotaError(err) {
+			return nil, err
+		}
+		log.Printf("Youtube API quota exceeded, searching youtube website for query [%s]", query)
+	}
+	videoIds, videos, err := innertubeSearch(query, resultNum)
+	if err != nil {
 		return nil, err
 	}
-	if len(ytSearchResponse.Items) == 0 {
-		log.Printf("No results found for the query: %s", query)
+	// search results have the metadata, so it needn't be fetched again
+	Cache.setVideos(videos)
+	return videoIds, nil
+}
+
+// video ids of search results in order
+func searchResultIds(items []*youtube.SearchResult) []string {
+	videoIds := make([]string, 0, len(items))
+	for _, item := range items {
+		videoIds = append(videoIds, item.Id.VideoId)
+	}
+	return videoIds
+}
+
+// get songs of search results in order
+func (ytservice *YTService) songsForSearchResults(videoIds []string, userName string) ([]*common.Song, error) {
+	songs, err := ytservice.songsForVideoIds(videoIds, userName)
+	if err != nil {
+		return nil, err
+	}
+	if len(songs) == 0 {
 		return nil, fmt.Errorf("No songs found for this query")
 	}
-	var songs []*common.Song
-	var errMsgs []string
-	wg := new(sync.WaitGroup)
-	wg.Add(len(ytSearchResponse.Items))
-	for _, item := range ytSearchResponse.Items {
-		go func(vidId string) {
-			defer wg.Done()
-
-			ytUrl := common.YoutubeVideoURLPrefix + vidId
-
-			song, err := GetSongWithStreamUrl(ytUrl, userName)
-			if err != nil {
-				errMsg := fmt.Sprintf("Failed to get song stream URL for song with id '%s'. Error [%s]", vidId, err.Error())
-				errMsgs = append(errMsgs, errMsg)
-				return
-			}
-			songs = append(songs, song)
-		}(item.Id.VideoId)
+	return songs, nil
+}
+
+// get songs of videos in order. Metadata of videos not in cache is fetched
+// with videos.list. Videos which are private, deleted or blocked are left out
+func (ytservice *YTService) songsForVideoIds(videoIds []string, userName string) ([]*common.Song, error) {
+	videos := make(map[string]*cachedVideo, len(videoIds))
+	missing := make([]string, 0)
+	for _, videoId := range videoIds {
+		if video, ok := Cache.getVideo(videoId); ok {
+			videos[videoId] = video
+		} else {
+			missing = append(missing, videoId)
+		}
 	}
-	log.Printf("Waiting for stream url for search results for query %s", query)
-	wg.Wait()
-	log.Printf("Fetched stream url for all search results for query %s", query)
-	if len(errMsgs) > 0 {
-		return nil, errors.New(strings.Join(errMsgs, ";"))
+	fetched, err := ytservice.fetchVideos(missing)
+	if err != nil {
+		return nil, err
+	}
+	Cache.setVideos(fetched)
+
+	songs := make([]*common.Song, 0, len(videoIds))
+	for _, videoId := range videoIds {
+		video, ok := videos[videoId]
+		if !ok {
+			video, ok = fetched[videoId]
+		}
+		if !ok {
+			log.Printf("[%s] Video is unavailable", videoId)
+			continue
+		}
+		songs = append(songs, videoSong(videoId, video, userName))
 	}
 	return songs, nil
 }
 
-func GetSongWithStreamUrl(url, userName string) (*common.Song, error) {
-	// get video info from url
-	videoInfo, err := downloadClient.GetVideo(url)
+// get metadata of videos with videos.list, 50 videos per request. Videos
+// which are unavailable are missing from the result
+func (ytservice *YTService) fetchVideos(videoIds []string) (map[string]*cachedVideo, error) {
+	if ytservice.ytService == nil {
+		return ytservice.fetchVideosWithoutApi(videoIds), nil
+	}
+	videos := make(map[string]*cachedVideo, len(videoIds))
+	for start := 0; start < len(videoIds); start += maxVideosPerRequest {
+		end := start + maxVideosPerRequest
+		if end > len(videoIds) {
+			end = len(videoIds)
+		}
+		response, err := ytservice.ytService.Videos.List([]string{"snippet", "contentDetails"}).Id(videoIds[start:end]...).Do()
+		if isQuotaError(err) {
+			log.Printf("Youtube API quota exceeded, getting details of videos from youtube website")
+			for videoId, video := range ytservice.fetchVideosWithoutApi(videoIds[start:]) {
+				videos[videoId] = video
+			}
+			return videos, nil
+		}
+		if err != nil {
+			log.Printf("Failed to get details of videos %v. Got error [%s]", videoIds[start:end], err.Error())
+			return nil, err
+		}
+		for _, video := range response.Items {
+			if video.Snippet == nil {
+				continue
+			}
+			var duration time.Duration
+			if video.ContentDetails != nil {
+				duration = parseIsoDuration(video.ContentDetails.Duration)
+			}
+			videos[video.Id] = &cachedVideo{
+				Title:       video.Snippet.Title,
+				ChannelId:   video.Snippet.ChannelId,
+				ChannelName: video.Snippet.ChannelTitle,
+				Duration:    duration,
+			}
+		}
+	}
+	return videos, nil
+}
+
+// get metadata of videos one by one with the download client, which needs no
+// API key
+func (ytservice *YTService) fetchVideosWithoutApi(videoIds []string) map[string]*cachedVideo {
+	videos := make(map[string]*cachedVideo, len(videoIds))
+	for _, videoId := range videoIds {
+		videoInfo, err := ytservice.downloadClient.GetVideo(common.YoutubeVideoURLPrefix + videoId)
+		if err != nil {
+			log.Printf("[%s] Failed to get video info. Got error: [%s]", videoId, err.Error())
+			continue
+		}
+		videos[videoId] = &cachedVideo{
+			Title:       videoInfo.Title,
+			ChannelId:   videoInfo.ChannelID,
+			ChannelName: videoInfo.Author,
+			Duration:    videoInfo.Duration,
+		}
+	}
+	return videos
+}
+
+// create a song from video metadata. Stream of the song is not resolved
+func videoSong(videoId string, video *cachedVideo, userName string) *common.Song {
+	return &common.Song{
+		SongId:       videoId,
+		SongTitle:    video.Title,
+		SongDuration: video.Duration,
+		User:         userName,
+		ChannelId:    video.ChannelId,
+		ChannelName:  video.ChannelName,
+		Source:       YoutubeSourceName,
+	}
+}
+
+// parse ISO 8601 durations used by the data API like 'PT1H2M3S'. Returns 0
+// for live streams and invalid durations
+func parseIsoDuration(isoDuration string) time.Duration {
+	match := isoDurationRegex.FindStringSubmatch(isoDuration)
+	if match == nil {
+		return 0
+	}
+	var duration time.Duration
+	units := []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second}
+	for idx, unit := range units {
+		value, _ := strconv.Atoi(match[idx+1])
+		duration += time.Duration(value) * unit
+	}
+	return duration
+}
+
+// get song for a youtube video url. Start timestamp is taken from 't' query
+// param of the url
+func (ytservice *YTService) Resolve(url, userName string) (*common.Song, error) {
+	videoId, err := youtubedr.ExtractVideoID(url)
+	if err != nil {
+		log.Printf("Failed to get video id from url '%s'. Got error: [%s]", url, err.Error())
+		return nil, fmt.Errorf("Couldn't get info for the song")
+	}
+	songs, err := ytservice.songsForVideoIds([]string{videoId}, userName)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get info for the song")
+	}
+	if len(songs) == 0 {
+		return nil, fmt.Errorf("Song is unavailable")
+	}
+	song := songs[0]
+	song.StartAt = getUrlTimestamp(url)
+	// duration of live streams is unknown, so their start is kept
+	if song.SongDuration > 0 && song.StartAt >= song.SongDuration {
+		song.StartAt = 0
+	}
+	return song, nil
+}
+
+// fetch stream url of a youtube song. Stream urls expire after a few hours so
+// they are fetched just before the song is played
+func (ytservice *YTService) StreamURL(song *common.Song) (*common.StreamInfo, error) {
+	videoInfo, err := ytservice.downloadClient.GetVideo(common.YoutubeVideoURLPrefix + song.SongId)
 	if err != nil {
 		log.Printf("Failed to get video info. Got error: [%s]", err.Error())
+		return nil, fmt.Errorf("Couldn't get info for the song")
 	}
-	songId := videoInfo.ID
-	songTitle := videoInfo.Title
-	channelId := videoInfo.ChannelID
-	channelName := videoInfo.Author
 	formats := videoInfo.Formats.WithAudioChannels().AudioChannels(2)
 	formats.Sort()
 	if len(formats) == 0 {
 		log.Printf("No formats for video with id '%s', title '%s'",
-			songId, songTitle)
+			song.SongId, song.SongTitle)
 		return nil, fmt.Errorf("No valid formats found for the song")
 	}
-	// take the best format after sorting
-	songUrl, err := downloadClient.GetStreamURL(videoInfo, &formats[0])
+	// prefer audio only opus formats which can be played without re-encoding,
+	// otherwise take the best format after sorting
+	format := &formats[0]
+	if opusFormat := getOpusFormat(formats); opusFormat != nil {
+		format = opusFormat
+	}
+	streamUrl, err := ytservice.downloadClient.GetStreamURL(videoInfo, format)
 	if err != nil {
 		log.Printf("Failed to fetch stream url for video with id '%s', title '%s'. Got error: %s",
-			songId, songTitle, err.Error())
+			song.SongId, song.SongTitle, err.Error())
 		return nil, fmt.Errorf("Couldn't find stream url for the song")
 	}
-	duration, _ := strconv.Atoi(formats[0].ApproxDurationMs)
-	songDuration := time.Duration(duration) * time.Millisecond
-	return &common.Song{
-		SongUrl:       songUrl,
-		SongId:        songId,
-		SongTitle:     songTitle,
-		SongDuration:  songDuration,
-		User:          userName,
-		ChannelId:     channelId,
-		ChannelName:   channelName,
-		YoutubeSource: true,
+	sampleRate, _ := strconv.Atoi(format.AudioSampleRate)
+	return &common.StreamInfo{
+		Url:        streamUrl,
+		MimeType:   format.MimeType,
+		SampleRate: sampleRate,
 	}, nil
 }
 
-// Search single or multiple results
-func (ytservice *YTService) SearchRelavantSongs(videoId, userName string, resultNum int64) ([]*common.Song, error) {
-	// search for the query
-	ytServiceSearchListCall := ytservice.ytService.Search.List([]string{"id"})
-	ytServiceSearchListCall.Type("video").VideoCategoryId("10").MaxResults(resultNum).RelatedToVideoId(videoId).VideoDuration("short").VideoSyndicated("any")
-	ytSearchResponse, err := ytServiceSearchListCall.Do()
+// get audio only WebM/opus format at 48KHz with highest bitrate
+func getOpusFormat(formats youtubedr.FormatList) *youtubedr.Format {
+	var opusFormat *youtubedr.Format
+	for idx := range formats {
+		format := &formats[idx]
+		if !strings.HasPrefix(format.MimeType, "audio/webm") || !strings.Contains(format.MimeType, "opus") ||
+			format.AudioSampleRate != strconv.Itoa(frameRate) {
+			continue
+		}
+		if opusFormat == nil || format.Bitrate > opusFormat.Bitrate {
+			opusFormat = format
+		}
+	}
+	return opusFormat
+}
+
+// get start timestamp from 't' query param of a youtube url
+func getUrlTimestamp(songUrl string) time.Duration {
+	parsedUrl, err := url.Parse(songUrl)
 	if err != nil {
-		log.Printf("Failed to search relevant songs for id [%s]. Got error [%s]", videoId, err.Error())
-		return nil, err
+		return 0
 	}
-	if len(ytSearchResponse.Items) == 0 {
-		log.Printf("No results found related to video id: %s", videoId)
-		return nil, fmt.Errorf("No songs found for this query")
+	timestamp := parsedUrl.Query().Get("t")
+	if timestamp == "" {
+		return 0
 	}
-	var songs []*common.Song
-	var errMsgs []string
-	wg := new(sync.WaitGroup)
-	wg.Add(len(ytSearchResponse.Items))
-	for _, item := range ytSearchResponse.Items {
-		go func(vidId string) {
-			defer wg.Done()
-
-			ytUrl := common.YoutubeVideoURLPrefix + vidId
-
-			song, err := GetSongWithStreamUrl(ytUrl, userName)
-			if err != nil {
-				log.Printf("Failed to get song stream URL. Got error: %s", err.Error())
-				errMsg := fmt.Sprintf("Failed to get song stream URL for song with id '%s'. Error [%s]", vidId, err.Error())
-				errMsgs = append(errMsgs, errMsg)
-				return
-			}
-			songs = append(songs, song)
-		}(item.Id.VideoId)
+	startAt, err := common.ParseTimestamp(timestamp)
+	if err != nil {
+		log.Printf("Failed to parse timestamp '%s' in url '%s'. Got error: %s", timestamp, songUrl, err.Error())
+		return 0
 	}
-	wg.Wait()
-	if len(errMsgs) > 0 {
-		return nil, errors.New(strings.Join(errMsgs, ";"))
+	return startAt
+}
+
+// Search songs related to a youtube song
+func (ytservice *YTService) Related(song *common.Song, userName string, resultNum int) ([]*common.Song, error) {
+	videoId := song.SongId
+	cacheKey := relatedCacheKeyPrefix + videoId
+	videoIds, ok := Cache.getSearch(cacheKey, resultNum)
+	if !ok {
+		if ytservice.ytService == nil {
+			return nil, errNoApiKey
+		}
+		// search for the query
+		ytServiceSearchListCall := ytservice.ytService.Search.List([]string{"id"})
+		ytServiceSearchListCall.Type("video").VideoCategoryId("10").MaxResults(int64(resultNum)).RelatedToVideoId(videoId).VideoDuration("short").VideoSyndicated("any")
+		ytSearchResponse, err := ytServiceSearchListCall.Do()
+		if err != nil {
+			log.Printf("Failed to search relevant songs for id [%s]. Got error [%s]", videoId, err.Error())
+			return nil, err
+		}
+		if len(ytSearchResponse.Items) == 0 {
+			log.Printf("No results found related to video id: %s", videoId)
+			return nil, fmt.Errorf("No songs found for this query")
+		}
+		videoIds = searchResultIds(ytSearchResponse.Items)
+		Cache.setSearch(cacheKey, videoIds, resultNum)
 	}
-	return songs, nil
+	return ytservice.songsForSearchResults(videoIds, userName)
 }
@@ -0,0 +1,42 @@
+/*
+author: Arshdeep Singh
+E-mail: ad.sigh.arsh@gmail.com
+*/
+
+package musicmanager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolveUrlTimestamp(t *testing.T) {
+	// videos are taken from the cache, so no request is made
+	Cache.setVideos(map[string]*cachedVideo{
+		"aaaaaaaaaaa": {Title: "song", Duration: 3 * time.Minute},
+		"bbbbbbbbbbb": {Title: "live stream"},
+	})
+	tests := []struct {
+		url     string
+		startAt time.Duration
+	}{
+		{url: "https://www.youtube.com/watch?v=aaaaaaaaaaa&t=90", startAt: 90 * time.Second},
+		{url: "https://youtu.be/aaaaaaaaaaa?t=1:30", startAt: 90 * time.Second},
+		{url: "https://www.youtube.com/watch?v=aaaaaaaaaaa", startAt: 0},
+		// start past the end of the song is dropped
+		{url: "https://www.youtube.com/watch?v=aaaaaaaaaaa&t=200", startAt: 0},
+		// start is kept when duration is unknown
+		{url: "https://www.youtube.com/watch?v=bbbbbbbbbbb&t=90", startAt: 90 * time.Second},
+	}
+	ytservice := &YTService{}
+	for _, test := range tests {
+		song, err := ytservice.Resolve(test.url, "user")
+		if err != nil {
+			t.Errorf("Resolve(%q) failed: %s", test.url, err.Error())
+			continue
+		}
+		if song.StartAt != test.startAt {
+			t.Errorf("Resolve(%q) starts at %s, want %s", test.url, song.StartAt, test.startAt)
+		}
+	}
+}